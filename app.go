@@ -1,58 +1,87 @@
 package main
 
 import (
-	"encoding/json"
-	"encoding/xml"
+	"context"
 	"errors"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
 	"time"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/appengine/blobstore"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/urlfetch"
 )
 
 var ErrUnspecified = errors.New("appengine-hosting: unspecified")
 
-var websites = map[string]WebsiteConfiguration{}
+var websites = &Cache[WebsiteConfiguration]{TTL: 5 * time.Minute, NegativeTTL: 10 * time.Second}
 var firebase = map[string]FirebaseConfiguration{}
+var firebases = &Cache[FirebaseConfiguration]{TTL: 5 * time.Minute, NegativeTTL: 10 * time.Second}
 
 type WebsiteConfiguration struct {
 	MainPageSuffix string
 	NotFoundPage   string
 }
 
-func init() {
-	f, err := os.Open("firebase.json")
-	if err == nil {
-		defer f.Close()
-		json.NewDecoder(f).Decode(&firebase)
-	}
-}
-
 type HandlerContext struct {
-	w        http.ResponseWriter
-	r        *http.Request
-	gcs      *http.Client
-	bucket   string
-	object   string
-	website  WebsiteConfiguration
-	firebase FirebaseConfiguration
+	w            http.ResponseWriter
+	r            *http.Request
+	storage      Storage
+	bucket       string
+	object       string
+	prefix       string // the root of the site within bucket
+	objectBucket string // where object is read from, after a rewrite to another bucket
+	objectPrefix string // the root object is relative to
+	canonical    string // the host to redirect to, if any
+	channel      string // the preview channel, if any
+	live         string // the host of the live site
+	local        string // the site in the local firebase.json, if not site()
+	release      string // the version of the release served, if any
+	unclean      bool   // the request path isn't clean, see cleanPath
+	website      WebsiteConfiguration
+	firebase     FirebaseConfiguration
+	trace        func(string)   // reports decisions, see withTrace
+	body         *http.Response // the object, if already opened by getObject
 }
 
 func StaticWebsiteHandler(w http.ResponseWriter, r *http.Request) HttpResult {
+	ctx := makeContext(w, r)
+	defer ctx.closeBody()
+	ctx.tracef("host %s: bucket %s, prefix %q", r.Host, ctx.bucket, ctx.prefix)
+
+	if ctx.canonical != "" {
+		ctx.tracef("canonical host %s", ctx.canonical)
+		return HttpResult{Status: http.StatusMovedPermanently, Location: ctx.getScheme() + "://" + ctx.canonical + r.URL.RequestURI()}
+	}
+
+	if ctx.channel != "" {
+		if res := ctx.initChannel(); res.Status != 0 {
+			return res
+		}
+		ctx.tracef("channel %s: bucket %s, prefix %q", ctx.channel, ctx.bucket, ctx.prefix)
+	}
+
+	if ctx.initRelease() != nil {
+		return HttpResult{Status: http.StatusInternalServerError}
+	}
+
+	if ctx.initFirebase() != nil {
+		return HttpResult{Status: http.StatusInternalServerError}
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		if proxy := ctx.getProxy(); proxy != "" {
+			ctx.traceRewrite(r.URL.Path)
+			return ctx.sendProxy(proxy)
+		}
+	}
+
 	if code := checkMethod(w, r); code != 0 {
 		return HttpResult{Status: code}
 	}
 
-	ctx := makeContext(w, r)
-
 	if code, location := ctx.getRedirect(); code != 0 {
+		ctx.traceRedirect(r.URL.Path)
 		return HttpResult{Status: code, Location: location + ctx.getQuery()}
 	}
 
@@ -60,27 +89,85 @@ func StaticWebsiteHandler(w http.ResponseWriter, r *http.Request) HttpResult {
 		return HttpResult{Status: http.StatusInternalServerError}
 	}
 
+	ctx.tracef("website: main page %q, not found page %q", ctx.website.MainPageSuffix, ctx.website.NotFoundPage)
+
+	if ctx.unclean || !within(ctx.objectPrefix, ctx.object) {
+		ctx.tracef("%s is not a clean path", ctx.object)
+		return ctx.sendNotFound()
+	}
+
 	if location := ctx.getCleanURL(); location != "" {
+		ctx.tracef("clean URL redirect to %s", location)
 		return HttpResult{Status: http.StatusMovedPermanently, Location: location + ctx.getQuery()}
 	}
 
+	if isHosting(ctx.objectPrefix, ctx.object) {
+		ctx.tracef("%s is never served", hostingPrefix)
+		return ctx.sendNotFound()
+	}
+
 	res := ctx.getMetadata()
 
 	if res.StatusCode == http.StatusNotFound {
+		if proxy := ctx.getProxy(); proxy != "" {
+			ctx.traceRewrite(r.URL.Path)
+			return ctx.sendProxy(proxy)
+		}
 		return ctx.sendNotFound()
 	}
 	if res.StatusCode != http.StatusOK {
 		return HttpResult{Status: res.StatusCode, Message: res.Status}
 	}
 
+	if header := ctx.getPrecompressed(res.Header); header != nil {
+		res.Header = header
+	}
+
 	etag := res.Header.Get("Etag")
 	lastModified := res.Header.Get("Last-Modified")
-	code := checkConditions(r, etag, lastModified, true)
+	immutable := ctx.immutable()
+	released := ctx.released()
+	var code int
+	if released {
+		if !immutable {
+			etag = releaseEtag(ctx.release, etag)
+		}
+		// Validate ranges against the same Etag, never by date.
+		res.Header.Set("Etag", etag)
+		res.Header.Del("Last-Modified")
+		code = checkConditions(r, etag, "", false)
+	} else {
+		code = checkConditions(r, etag, lastModified, !immutable)
+	}
 
+	encoding := storedEncoding(res.Header)
+	sent := encoding
+	switch {
+	case encoding == "identity" && ctx.compressible(res.Header.Get("Content-Type")):
+		sent = "gzip"
+	case !acceptsEncoding(r, encoding):
+		sent = "identity"
+	}
+	if sent != encoding && encoding != "gzip" && encoding != "identity" {
+		ctx.tracef("stored %s encoding not acceptable", encoding)
+		return HttpResult{Status: http.StatusNotAcceptable}
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if immutable || released {
+		if sent != encoding {
+			etag = "W/" + etag
+		}
+		w.Header().Set("Etag", etag)
+	}
+	if !immutable {
+		lastModified = time.Now().UTC().Format(http.TimeFormat)
+	}
 	if code == http.StatusNotModified {
 		w.Header()["Cache-Control"] = res.Header["Cache-Control"]
 	}
 	if code != 0 {
+		ctx.tracef("conditional request: %d", code)
 		return HttpResult{Status: code}
 	}
 
@@ -88,63 +175,81 @@ func StaticWebsiteHandler(w http.ResponseWriter, r *http.Request) HttpResult {
 	w.Header()["Content-Type"] = res.Header["Content-Type"]
 	w.Header()["Content-Language"] = res.Header["Content-Language"]
 	w.Header()["Content-Disposition"] = res.Header["Content-Disposition"]
-	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	w.Header().Set("Last-Modified", lastModified)
 
 	ctx.setHeaders()
-	if res.Header.Get("x-goog-stored-content-encoding") == "identity" {
-		return ctx.sendBlob(etag, lastModified, true)
+	if sent != "identity" {
+		w.Header().Set("Content-Encoding", sent)
+	}
+	if sent == encoding {
+		return ctx.sendBlob(res.Header)
 	} else {
-		return ctx.sendBlobBody()
+		ctx.tracef("transcode from %s to %s", encoding, sent)
+		return ctx.sendBlobBody(res.Header)
 	}
 }
 
 func makeContext(w http.ResponseWriter, r *http.Request) HandlerContext {
-	bucket := r.Host
+	live, channel := splitChannel(normalizeHost(r.Host))
+	bucket, prefix, canonical := resolveHost(live)
+	if canonical != "" {
+		canonical = joinChannel(canonical, channel)
+	}
 	object := r.URL.EscapedPath()
-	source, _ := google.DefaultTokenSource(r.Context(), "https://www.googleapis.com/auth/devstorage.read_only")
+	trace, _ := r.Context().Value(traceKey{}).(func(string))
 
 	return HandlerContext{
-		w:        w,
-		r:        r,
-		bucket:   bucket,
-		object:   object,
-		website:  websites[bucket],
-		firebase: firebase[bucket],
-		gcs: &http.Client{
-			Transport: &oauth2.Transport{
-				Base:   &urlfetch.Transport{Context: r.Context()},
-				Source: source,
-			},
-		},
+		w:            w,
+		r:            r,
+		storage:      storage,
+		bucket:       bucket,
+		object:       object,
+		prefix:       prefix,
+		objectBucket: bucket,
+		objectPrefix: prefix,
+		canonical:    canonical,
+		channel:      channel,
+		live:         live,
+		unclean:      !cleanPath(object),
+		trace:        trace,
 	}
 }
 
-func (ctx *HandlerContext) initWebsite() error {
-	if _, ok := websites[ctx.bucket]; ok {
-		return nil
+// cleanPath reports whether an escaped path, once unescaped, is clean,
+// so that, unescaped and cleaned by storage, it can't climb out of a prefix.
+func cleanPath(escaped string) bool {
+	if escaped == "" {
+		return true
 	}
-
-	res, err := ctx.gcs.Get("https://storage.googleapis.com/" + ctx.bucket + "?websiteConfig")
-
-	if err != nil {
-		log.Errorf(ctx.r.Context(), "GET %s?websiteConfig: %v", ctx.bucket, err)
-		return err
+	name, err := url.PathUnescape(escaped)
+	if err != nil || name[0] != '/' {
+		return false
 	}
-
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		log.Errorf(ctx.r.Context(), "GET %s?websiteConfig: %s", ctx.bucket, http.StatusText(res.StatusCode))
-		return ErrUnspecified
+	clean := path.Clean(name)
+	if strings.HasSuffix(name, "/") && clean != "/" {
+		clean += "/"
 	}
+	return clean == name
+}
 
-	if err = xml.NewDecoder(res.Body).Decode(&ctx.website); err != nil {
-		log.Errorf(ctx.r.Context(), "Decode %s?websiteConfig: %v", ctx.bucket, err)
-		return err
+// within reports whether an object, once unescaped and cleaned, is under prefix.
+func within(prefix, object string) bool {
+	name, err := url.PathUnescape(prefix + object)
+	if err != nil {
+		return false
 	}
+	return strings.HasPrefix(path.Clean("/"+name)+"/", prefix+"/")
+}
 
-	websites[ctx.bucket] = ctx.website
-	return nil
+func (ctx *HandlerContext) initWebsite() (err error) {
+	ctx.website, err = websites.Get(ctx.bucket, func() (WebsiteConfiguration, error) {
+		website, err := ctx.storage.Website(context.WithoutCancel(ctx.r.Context()), ctx.bucket)
+		if err != nil {
+			logErrorf(ctx.r.Context(), "Website %s: %v", ctx.bucket, err)
+		}
+		return website, err
+	})
+	return err
 }
 
 func (ctx *HandlerContext) getMetadata() *http.Response {
@@ -155,7 +260,7 @@ func (ctx *HandlerContext) getMetadata() *http.Response {
 		ctx.object = mainPageSuffix
 	}
 	if len(ctx.object) <= 1 || ctx.object == notFoundPage {
-		if r := ctx.getRewriteMetadata(ctx.firebase.processRewrites(ctx.r.URL.Path)); r != nil {
+		if r := ctx.getRewriteMetadata(ctx.getRewrite()); r != nil {
 			return r
 		}
 		return &http.Response{StatusCode: http.StatusNotFound}
@@ -164,22 +269,21 @@ func (ctx *HandlerContext) getMetadata() *http.Response {
 		ctx.object = strings.TrimRight(ctx.object, "/")
 	}
 
-	res, err := ctx.gcs.Head("https://storage.googleapis.com/" + ctx.bucket + ctx.object)
+	res, err := ctx.getObject()
 
 	if err != nil {
-		log.Errorf(ctx.r.Context(), "HEAD %s: %v", ctx.bucket+ctx.object, err)
+		logErrorf(ctx.r.Context(), "GET %s: %v", ctx.objectBucket+ctx.objectName(), err)
 		return &http.Response{StatusCode: http.StatusInternalServerError}
 	}
 	if res.StatusCode == http.StatusNotFound || strings.HasSuffix(ctx.object, "/") && res.Header.Get("x-goog-stored-content-length") == "0" {
-		if r := ctx.getRewriteMetadata(strings.TrimRight(ctx.object, "/") + mainPageSuffix); r != nil {
-			return r
-		}
+		ctx.closeBody()
+		path := strings.TrimRight(ctx.object, "/")
+		candidates := []candidate{{ctx.bucket, ctx.prefix, path + mainPageSuffix}}
 		if ctx.firebase.CleanUrls {
-			if r := ctx.getRewriteMetadata(strings.TrimRight(ctx.object, "/") + ".html"); r != nil {
-				return r
-			}
+			candidates = append(candidates, candidate{ctx.bucket, ctx.prefix, path + ".html"})
 		}
-		if r := ctx.getRewriteMetadata(ctx.firebase.processRewrites(ctx.r.URL.Path)); r != nil {
+		candidates = append(candidates, ctx.getRewrite())
+		if r := ctx.getRewriteMetadata(candidates...); r != nil {
 			return r
 		}
 	}
@@ -187,21 +291,158 @@ func (ctx *HandlerContext) getMetadata() *http.Response {
 	return res
 }
 
-func (ctx *HandlerContext) getRewriteMetadata(rewrite string) *http.Response {
-	if len(rewrite) > 1 && rewrite[0] == '/' && rewrite != ctx.object {
-		res, err := ctx.gcs.Head("https://storage.googleapis.com/" + ctx.bucket + rewrite)
-		if err != nil {
-			log.Errorf(ctx.r.Context(), "HEAD %s: %v", ctx.bucket+ctx.object, err)
+// getObject gets the metadata of the object with a HEAD, or, if getsBody,
+// with a GET whose body is kept for sendBlobBody.
+func (ctx *HandlerContext) getObject() (*http.Response, error) {
+	bucket, name := ctx.objectBucket, ctx.objectName()
+
+	if !ctx.getsBody() {
+		res, err := ctx.storage.Stat(ctx.r.Context(), bucket, name)
+		if err == nil {
+			ctx.tracef("HEAD %s: %d", bucket+name, res.StatusCode)
+		}
+		return res, err
+	}
+
+	header := http.Header{"Accept-Encoding": {"gzip"}}
+	res, err := ctx.storage.Open(ctx.r.Context(), bucket, name, header)
+	if err != nil {
+		return nil, err
+	}
+	ctx.tracef("GET %s: %d", bucket+name, res.StatusCode)
+	if res.StatusCode == http.StatusOK {
+		ctx.body = res
+	} else {
+		res.Body.Close()
+	}
+	return res, nil
+}
+
+// getsBody reports whether the body of the object will be sent from storage:
+// for unconditional GET requests, without ranges, unless the object is sent
+// by other means, or the site has precompressed siblings the client accepts.
+// Conditional requests are evaluated against the validators the handler sends,
+// not those of storage.
+func (ctx *HandlerContext) getsBody() bool {
+	if ctx.r.Method != "GET" || ctx.r.Header.Get("Range") != "" {
+		return false
+	}
+	for _, k := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if _, ok := ctx.r.Header[k]; ok {
+			return false
+		}
+	}
+	if ctx.hasPrecompressed() {
+		return false
+	}
+	return ctx.streamsBody()
+}
+
+func (ctx *HandlerContext) closeBody() {
+	if ctx.body != nil {
+		ctx.body.Body.Close()
+		ctx.body = nil
+	}
+}
+
+// candidate is an object that may be served in place of a missing one.
+type candidate struct {
+	bucket, prefix, object string
+}
+
+// getRewriteMetadata probes the candidates concurrently, and moves the context
+// to the first one, in order, that isn't missing; the other probes are cancelled.
+func (ctx *HandlerContext) getRewriteMetadata(candidates ...candidate) *http.Response {
+	var probes []candidate
+	for _, c := range candidates {
+		if len(c.object) > 1 && c.object[0] == '/' && !isHosting(c.prefix, c.object) && (c.object != ctx.object || c.bucket != ctx.objectBucket || c.prefix != ctx.objectPrefix) {
+			probes = append(probes, c)
+		}
+	}
+
+	type result struct {
+		res *http.Response
+		err error
+	}
+
+	cctx, cancel := context.WithCancel(ctx.r.Context())
+	results := make([]chan result, len(probes))
+	for i, c := range probes {
+		results[i] = make(chan result, 1)
+		go func(c candidate, out chan<- result) {
+			res, err := ctx.storage.Stat(cctx, c.bucket, c.prefix+c.object)
+			out <- result{res, err}
+		}(c, results[i])
+	}
+
+	// Cancel the probes still pending, and release their responses.
+	defer func() {
+		cancel()
+		go func(pending []chan result) {
+			for _, out := range pending {
+				if r := <-out; r.err == nil {
+					r.res.Body.Close()
+				}
+			}
+		}(results)
+	}()
+
+	for len(results) > 0 {
+		c, r := probes[0], <-results[0]
+		probes, results = probes[1:], results[1:]
+
+		if r.err != nil {
+			logErrorf(ctx.r.Context(), "HEAD %s: %v", c.bucket+c.prefix+c.object, r.err)
 			return &http.Response{StatusCode: http.StatusInternalServerError}
 		}
-		if res.StatusCode != http.StatusNotFound {
-			ctx.object = rewrite
-			return res
+		ctx.tracef("HEAD %s: %d", c.bucket+c.prefix+c.object, r.res.StatusCode)
+		if r.res.StatusCode != http.StatusNotFound {
+			ctx.objectBucket = c.bucket
+			ctx.objectPrefix = c.prefix
+			ctx.object = c.object
+			return r.res
 		}
+		r.res.Body.Close()
 	}
 	return nil
 }
 
+// getRewrite resolves the rewrite destination for the request to a candidate object.
+//
+// Destinations may name another bucket, as in gs://bucket/object,
+// otherwise they're relative to the root of the site.
+// Destinations ending in a slash are prefixes, to which the request path is appended.
+func (ctx *HandlerContext) getRewrite() candidate {
+	object, ok := ctx.firebase.processRewrite(ctx.r.URL.Path)
+	if !ok {
+		return candidate{ctx.bucket, ctx.prefix, ctx.r.URL.Path}
+	}
+	ctx.traceRewrite(ctx.r.URL.Path)
+
+	bucket, prefix := ctx.bucket, ctx.prefix
+	if b, ok := rewriteBucket(object); ok {
+		bucket, prefix, object = b, "", strings.TrimPrefix(object, "gs://"+b)
+		if object == "" {
+			object = "/"
+		}
+	}
+	if strings.HasSuffix(object, "/") {
+		object = strings.TrimSuffix(object, "/") + ctx.r.URL.EscapedPath()
+	}
+
+	return candidate{bucket, prefix, object}
+}
+
+// objectName is the name of the object in its bucket.
+func (ctx *HandlerContext) objectName() string {
+	return ctx.objectPrefix + ctx.object
+}
+
+// site identifies the site being served, a bucket or a prefix in a bucket.
+func (ctx *HandlerContext) site() string {
+	return ctx.bucket + ctx.prefix
+}
+
 func (ctx *HandlerContext) getRedirect() (int, string) {
 	return ctx.firebase.processRedirects(ctx.r.URL.Path)
 }
@@ -228,6 +469,13 @@ func (ctx *HandlerContext) getCleanURL() string {
 	return ""
 }
 
+func (ctx *HandlerContext) getScheme() string {
+	if ctx.r.TLS != nil || ctx.r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
+
 func (ctx *HandlerContext) getQuery() string {
 	query := ctx.r.URL.Query()
 	if len(query) == 0 {
@@ -239,46 +487,85 @@ func (ctx *HandlerContext) getQuery() string {
 func (ctx *HandlerContext) setHeaders() {
 	setHeaders(ctx.w.Header())
 	ctx.firebase.processHeaders(ctx.r.URL.Path, ctx.w.Header())
+	ctx.traceHeaders(ctx.r.URL.Path)
 }
 
-func (ctx *HandlerContext) sendBlob(etag string, modified string, mutable bool) HttpResult {
-	key, err := blobstore.BlobKeyForFile(ctx.r.Context(), "/gs/"+ctx.bucket+ctx.object)
-	if err != nil {
-		log.Errorf(ctx.r.Context(), "BlobKeyForFile /gs/%s: %v", ctx.bucket+ctx.object, err)
-		return HttpResult{Status: http.StatusInternalServerError}
+func (ctx *HandlerContext) sendBlobBody(metadata http.Header) HttpResult {
+	etag := metadata.Get("Etag")
+	lastModified := metadata.Get("Last-Modified")
+	stored := storedEncoding(metadata)
+	sent := ctx.w.Header().Get("Content-Encoding")
+	if sent == "" {
+		sent = "identity"
 	}
 
-	if header := ctx.r.Header.Get("Range"); len(header) > 0 {
-		condition := ctx.r.Header.Get("If-Range")
-		if len(condition) != 0 && condition != etag && condition != modified {
-			header = ""
+	if sent == stored {
+		size, err := strconv.ParseInt(metadata.Get("x-goog-stored-content-length"), 10, 64)
+		if err == nil && stored == "identity" {
+			ctx.w.Header().Set("Accept-Ranges", "bytes")
+
+			var ranges []httpRange
+			if header := ctx.r.Header.Get("Range"); header != "" && checkIfRange(ctx.r, etag, lastModified) {
+				ranges, err = parseRange(header, size)
+			}
+			if err == errNoOverlap {
+				ctx.w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+				ctx.w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return HttpResult{}
+			}
+			if err == nil && len(ranges) > 0 && len(ranges) <= maxRanges && sumRanges(ranges) <= size {
+				return ctx.sendRanges(mergeRanges(ranges), size)
+			}
 		}
-		if mutable {
-			header = ""
+		if err == nil {
+			ctx.w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 		}
-		ctx.w.Header().Set("X-AppEngine-BlobRange", header)
 	}
 
-	ctx.w.Header().Set("X-AppEngine-BlobKey", string(key))
-	return HttpResult{}
-}
+	if ctx.r.Method == "HEAD" {
+		return HttpResult{}
+	}
 
-func (ctx *HandlerContext) sendBlobBody() HttpResult {
-	res, err := ctx.gcs.Get("https://storage.googleapis.com/" + ctx.bucket + ctx.object)
+	res := ctx.body
+	if res == nil {
+		var header http.Header
+		if stored != "identity" {
+			header = http.Header{"Accept-Encoding": {stored}}
+		}
 
-	if err != nil {
-		log.Errorf(ctx.r.Context(), "GET %s: %v", ctx.bucket+ctx.object, err)
-		return HttpResult{Status: http.StatusInternalServerError}
-	}
+		var err error
+		res, err = ctx.storage.Open(ctx.r.Context(), ctx.objectBucket, ctx.objectName(), header)
 
-	defer res.Body.Close()
+		if err != nil {
+			logErrorf(ctx.r.Context(), "GET %s: %v", ctx.objectBucket+ctx.objectName(), err)
+			return HttpResult{Status: http.StatusInternalServerError}
+		}
+
+		defer res.Body.Close()
+	}
 
 	if res.StatusCode != http.StatusOK {
-		log.Errorf(ctx.r.Context(), "GET %s: %s", ctx.bucket+ctx.object, http.StatusText(res.StatusCode))
+		logErrorf(ctx.r.Context(), "GET %s: %s", ctx.objectBucket+ctx.objectName(), http.StatusText(res.StatusCode))
 		return HttpResult{Status: http.StatusInternalServerError}
 	}
 
-	io.Copy(ctx.w, res.Body)
+	received := strings.ToLower(res.Header.Get("Content-Encoding"))
+	if received == "" && storedEncoding(res.Header) == "identity" {
+		received = stored // stored as is, without metadata, like precompressed siblings
+	}
+	if received == "" {
+		received = "identity"
+	}
+	if received != stored {
+		ctx.w.Header().Del("Content-Length")
+	}
+
+	if err := transcode(ctx.w, res.Body, received, sent); err != nil {
+		logErrorf(ctx.r.Context(), "GET %s: %v", ctx.objectBucket+ctx.objectName(), err)
+		if err == errUnsupportedEncoding {
+			return HttpResult{Status: http.StatusInternalServerError}
+		}
+	}
 	return HttpResult{}
 }
 
@@ -289,17 +576,21 @@ func (ctx *HandlerContext) sendNotFound() HttpResult {
 		return HttpResult{Status: http.StatusNotFound}
 	}
 
-	res, err := ctx.gcs.Get("https://storage.googleapis.com/" + ctx.bucket + notFoundPage)
+	res, err := ctx.storage.Open(ctx.r.Context(), ctx.bucket, ctx.prefix+notFoundPage, nil)
 
 	if err != nil {
-		log.Errorf(ctx.r.Context(), "GET %s: %v", ctx.bucket+notFoundPage, err)
+		logErrorf(ctx.r.Context(), "GET %s: %v", ctx.site()+notFoundPage, err)
 		return HttpResult{Status: http.StatusInternalServerError}
 	}
 
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotFound {
+		ctx.tracef("not found page %s is missing", ctx.site()+notFoundPage)
+		return HttpResult{Status: http.StatusNotFound}
+	}
 	if res.StatusCode != http.StatusOK {
-		log.Errorf(ctx.r.Context(), "GET %s: %s", ctx.bucket+notFoundPage, http.StatusText(res.StatusCode))
+		logErrorf(ctx.r.Context(), "GET %s: %s", ctx.site()+notFoundPage, http.StatusText(res.StatusCode))
 		return HttpResult{Status: http.StatusInternalServerError}
 	}
 
@@ -307,18 +598,23 @@ func (ctx *HandlerContext) sendNotFound() HttpResult {
 	ctx.w.Header()["Content-Language"] = res.Header["Content-Language"]
 	ctx.w.Header()["Content-Disposition"] = res.Header["Content-Disposition"]
 
+	ctx.tracef("not found page %s", ctx.site()+notFoundPage)
 	setHeaders(ctx.w.Header())
 	ctx.firebase.processHeaders(notFoundPage, ctx.w.Header())
+	ctx.traceHeaders(notFoundPage)
 	ctx.w.WriteHeader(http.StatusNotFound)
 	io.Copy(ctx.w, res.Body)
 	return HttpResult{}
 }
 
+// checkConditions evaluates the preconditions of the request;
+// without lastModified, those on dates are ignored.
 func checkConditions(r *http.Request, etag string, lastModified string, mutable bool) int {
 	modified, err := http.ParseTime(lastModified)
+	dated := lastModified != ""
 
-	if etag == "" || etag[0] != '"' || err != nil {
-		log.Errorf(r.Context(), "checkConditions: invalid etag/lastModified")
+	if etag == "" || etag[0] != '"' || err != nil && dated {
+		logErrorf(r.Context(), "checkConditions: invalid etag/lastModified")
 		return http.StatusInternalServerError
 	}
 
@@ -333,7 +629,7 @@ func checkConditions(r *http.Request, etag string, lastModified string, mutable
 		if !match {
 			return http.StatusPreconditionFailed
 		}
-	} else {
+	} else if dated {
 		since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
 		if err == nil && (modified.After(since) || mutable) {
 			return http.StatusPreconditionFailed
@@ -351,7 +647,7 @@ func checkConditions(r *http.Request, etag string, lastModified string, mutable
 		if match {
 			return http.StatusNotModified
 		}
-	} else {
+	} else if dated {
 		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
 		if err == nil && !modified.After(since) {
 			return http.StatusNotModified
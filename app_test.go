@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memStorage map[string]string
+
+func (m memStorage) Stat(ctx context.Context, bucket, object string) (*http.Response, error) {
+	res, err := m.Open(ctx, bucket, object, nil)
+	if err == nil {
+		res.Body = http.NoBody
+	}
+	return res, err
+}
+
+func (m memStorage) Open(ctx context.Context, bucket, object string, header http.Header) (*http.Response, error) {
+	content, ok := m[bucket+object]
+	if !ok {
+		return &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}, Body: http.NoBody}, nil
+	}
+
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	res.Header.Set("Etag", `"`+strconv.Itoa(len(content))+`"`)
+	res.Header.Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+	res.Header.Set("Content-Type", "text/html")
+	res.Header.Set("x-goog-stored-content-length", strconv.Itoa(len(content)))
+	res.Body = ioutil.NopCloser(strings.NewReader(content))
+	return res, nil
+}
+
+func (m memStorage) Website(ctx context.Context, bucket string) (WebsiteConfiguration, error) {
+	return WebsiteConfiguration{MainPageSuffix: "index.html", NotFoundPage: "404.html"}, nil
+}
+
+func setStorage(t *testing.T, s Storage) {
+	storage = s
+	firebase = map[string]FirebaseConfiguration{}
+	firebases = &Cache[FirebaseConfiguration]{}
+	websites = &Cache[WebsiteConfiguration]{}
+	channels = &Cache[map[string]ChannelConfiguration]{}
+	releases = &Cache[ReleaseConfiguration]{}
+	manifests = &Cache[*Manifest]{}
+	precompressed = &Cache[http.Header]{}
+	precompressedSites = &sync.Map{}
+	t.Cleanup(func() { storage = GCSStorage{} })
+}
+
+func serve(method, target string) (*httptest.ResponseRecorder, HttpResult) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, target, nil)
+	res := StaticWebsiteHandler(w, r)
+	if res.Status == 0 {
+		res.Status = w.Code
+	}
+	return w, res
+}
+
+func Test_StaticWebsiteHandler(t *testing.T) {
+	setStorage(t, memStorage{
+		"example.com/index.html":      "home",
+		"example.com/404.html":        "missing",
+		"example.com/about.html":      "about",
+		"example.com/blog/index.html": "blog",
+		"example.com/app/index.html":  "app",
+	})
+
+	tests := []struct {
+		method   string
+		target   string
+		status   int
+		location string
+		body     string
+	}{
+		{"GET", "http://example.com/", http.StatusOK, "", "home"},
+		{"GET", "http://example.com/about.html", http.StatusOK, "", "about"},
+		{"GET", "http://example.com/blog/", http.StatusOK, "", "blog"},
+		{"GET", "http://example.com/missing", http.StatusNotFound, "", "missing"},
+		{"POST", "http://example.com/", http.StatusMethodNotAllowed, "", ""},
+	}
+
+	for _, tt := range tests {
+		w, res := serve(tt.method, tt.target)
+		if res.Status != tt.status || res.Location != tt.location {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.method, tt.target, res.Status, res.Location, tt.status, tt.location)
+		}
+		if body := w.Body.String(); body != tt.body {
+			t.Errorf("%s %s: got body %q, want %q", tt.method, tt.target, body, tt.body)
+		}
+	}
+}
+
+func Test_initFirebase(t *testing.T) {
+	setStorage(t, memStorage{
+		"example.com/.hosting/firebase.json": `{"hosting": {"redirects": [{"source": "/old", "destination": "/new", "type": 302}], "cleanUrls": true}}`,
+		"example.com/about.html":             "about",
+		"example.com/404.html":               "missing",
+		"example.org/about.html":             "about",
+	})
+	firebase["example.org"] = FirebaseConfiguration{CleanUrls: false}
+	firebase["example.com"] = FirebaseConfiguration{CleanUrls: false}
+
+	tests := []struct {
+		target   string
+		status   int
+		location string
+	}{
+		{"http://example.com/old", http.StatusFound, "/new"},
+		{"http://example.com/about.html", http.StatusMovedPermanently, "/about"},
+		{"http://example.com/about", http.StatusOK, ""},
+		{"http://example.com/.hosting/firebase.json", http.StatusNotFound, ""},
+		{"http://example.org/about.html", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		_, res := serve("GET", tt.target)
+		if res.Status != tt.status || res.Location != tt.location {
+			t.Errorf("GET %s: got %d %q, want %d %q", tt.target, res.Status, res.Location, tt.status, tt.location)
+		}
+	}
+}
+
+func Test_hostingHidden(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "example.com", ".hosting"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "example.com", ".hosting", "firebase.json"), []byte(`{"rewrites": [{"source": "/app/**", "destination": "/"}]}`), 0644)
+	ioutil.WriteFile(filepath.Join(root, "example.com", ".hosting", "index.html"), []byte("hidden"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "example.com", "404.html"), []byte("missing"), 0644)
+	setStorage(t, newLocalStorage(root))
+
+	for _, target := range []string{
+		"/.hosting/firebase.json",
+		"/%2Ehosting/firebase.json",
+		"/%2ehosting%2Ffirebase.json",
+		"/a/../.hosting/firebase.json",
+		"/.hosting",
+		"/%2Ehosting/",
+		"/app/.hosting/firebase.json",
+	} {
+		w, res := serve("GET", "http://example.com"+target)
+		if res.Status != http.StatusNotFound || w.Body.String() != "missing" {
+			t.Errorf("GET %s: got %d %q", target, res.Status, w.Body.String())
+		}
+	}
+
+	release := filepath.Join(root, "example.org", ".hosting", "releases", "v1")
+	os.MkdirAll(filepath.Join(release, ".hosting"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "example.org", ".hosting", "release.json"), []byte(`{"version": "v1"}`), 0644)
+	ioutil.WriteFile(filepath.Join(release, ".hosting", "firebase.json"), []byte(`{"rewrites": [{"source": "/app/**", "destination": "/../../release.json"}]}`), 0644)
+	ioutil.WriteFile(filepath.Join(release, "404.html"), []byte("missing v1"), 0644)
+
+	for _, target := range []string{
+		"/../../release.json",
+		"/%2E%2E/%2E%2E/release.json",
+		"/..%2F..%2Frelease.json",
+		"/app/release.json",
+	} {
+		w, res := serve("GET", "http://example.org"+target)
+		if res.Status != http.StatusNotFound || w.Body.String() != "missing v1" {
+			t.Errorf("GET %s: got %d %q", target, res.Status, w.Body.String())
+		}
+	}
+}
+
+func Test_getRewrite(t *testing.T) {
+	setStorage(t, memStorage{
+		"example.com/404.html":               "missing",
+		"example.com/v2/docs/a.html":         "v2 docs",
+		"assets/v3/static/app.js":            "app",
+		"assets/shell.html":                  "shell",
+		"example.org/.hosting/firebase.json": `{"rewrites": [{"source": "**", "destination": "gs://assets/shell.html"}]}`,
+	})
+
+	var config FirebaseConfiguration
+	decodeStrict(strings.NewReader(`{"rewrites": [
+  {"source": "/static/**", "destination": "gs://assets/v3/"},
+  {"source": "/docs/**", "destination": "/v2/"},
+  {"source": "/app/**", "destination": "gs://assets/shell.html"}
+]}`), &config)
+	config.compile()
+	firebase["example.com"] = config
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"http://example.com/static/app.js", http.StatusOK, "app"},
+		{"http://example.com/docs/a.html", http.StatusOK, "v2 docs"},
+		{"http://example.com/app/settings", http.StatusOK, "shell"},
+		{"http://example.com/static/missing.js", http.StatusNotFound, "missing"},
+		{"http://example.org/anything", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		w, res := serve("GET", tt.target)
+		if res.Status != tt.status || w.Body.String() != tt.body {
+			t.Errorf("GET %s: got %d %q, want %d %q", tt.target, res.Status, w.Body.String(), tt.status, tt.body)
+		}
+	}
+}
+
+// barrierStorage answers HEAD requests once all the expected ones are in flight.
+type barrierStorage struct {
+	memStorage
+	wg *sync.WaitGroup
+}
+
+func (s barrierStorage) Stat(ctx context.Context, bucket, object string) (*http.Response, error) {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	s.wg.Done()
+	select {
+	case <-done:
+		return s.memStorage.Stat(ctx, bucket, object)
+	case <-time.After(time.Second):
+		return nil, context.DeadlineExceeded
+	}
+}
+
+func Test_getRewriteMetadata(t *testing.T) {
+	tests := []struct {
+		files []string
+		body  string
+	}{
+		{[]string{"/docs/index.html", "/docs.html", "/shell.html"}, "/docs/index.html"},
+		{[]string{"/docs.html", "/shell.html"}, "/docs.html"},
+		{[]string{"/shell.html"}, "/shell.html"},
+	}
+
+	for _, tt := range tests {
+		mem := memStorage{}
+		for _, f := range tt.files {
+			mem["example.com"+f] = f
+		}
+		var wg sync.WaitGroup
+		wg.Add(3)
+		setStorage(t, barrierStorage{mem, &wg})
+
+		var config FirebaseConfiguration
+		decodeStrict(strings.NewReader(`{"cleanUrls": true, "rewrites": [{"source": "**", "destination": "/shell.html"}]}`), &config)
+		config.compile()
+		firebase["example.com"] = config
+
+		w, res := serve("GET", "http://example.com/docs")
+		if res.Status != http.StatusOK || w.Body.String() != tt.body {
+			t.Errorf("GET /docs with %v: got %d %q, want %q", tt.files, res.Status, w.Body.String(), tt.body)
+		}
+	}
+}
+
+// cachedStorage adds a Cache-Control header to objects.
+type cachedStorage struct {
+	memStorage
+}
+
+func (s cachedStorage) Stat(ctx context.Context, bucket, object string) (*http.Response, error) {
+	res, err := s.memStorage.Stat(ctx, bucket, object)
+	if err == nil && res.StatusCode == http.StatusOK {
+		res.Header.Set("Cache-Control", "max-age=60")
+	}
+	return res, err
+}
+
+func Test_getObject(t *testing.T) {
+	mem := memStorage{
+		"example.com/about.html":      "about",
+		"example.com/blog/index.html": "blog",
+		"example.com/404.html":        "missing",
+	}
+
+	tests := []struct {
+		method string
+		target string
+		header string
+		value  string
+		status int
+		stats  int
+		opens  int
+	}{
+		{"GET", "/about.html", "", "", http.StatusOK, 0, 1},
+		{"HEAD", "/about.html", "", "", http.StatusOK, 1, 0},
+		{"GET", "/about.html", "Range", "bytes=0-1", http.StatusPartialContent, 1, 1},
+		{"GET", "/about.html", "If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusNotModified, 1, 0},
+		{"GET", "/about.html", "If-Modified-Since", "Mon, 02 Jan 2006 15:04:04 GMT", http.StatusOK, 1, 1},
+		{"GET", "/about.html", "If-None-Match", `"5"`, http.StatusNotModified, 1, 0},
+		{"GET", "/blog", "", "", http.StatusOK, 1, 2},
+		{"GET", "/missing", "", "", http.StatusNotFound, 1, 2},
+	}
+
+	for _, tt := range tests {
+		counter := &countingStorage{Storage: cachedStorage{mem}}
+		setStorage(t, counter)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, "http://example.com"+tt.target, nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		res := StaticWebsiteHandler(w, r)
+		if res.Status == 0 {
+			res.Status = w.Code
+		}
+
+		counter.opens -= 2 // release.json and firebase.json
+		if res.Status != tt.status || counter.stats != tt.stats || counter.opens != tt.opens {
+			t.Errorf("%s %s %s: got %d (%d HEAD, %d GET), want %d (%d HEAD, %d GET)", tt.method, tt.target, tt.header, res.Status, counter.stats, counter.opens, tt.status, tt.stats, tt.opens)
+		}
+		if counter.header.Get("If-None-Match") != "" || counter.header.Get("If-Modified-Since") != "" {
+			t.Errorf("%s %s %s: conditional request forwarded to storage", tt.method, tt.target, tt.header)
+		}
+		if tt.status == http.StatusNotModified && w.Header().Get("Cache-Control") != "max-age=60" {
+			t.Errorf("%s %s %s: got Cache-Control %q", tt.method, tt.target, tt.header, w.Header().Get("Cache-Control"))
+		}
+	}
+}
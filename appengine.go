@@ -0,0 +1,72 @@
+//go:build gae
+// +build gae
+
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/appengine/v2"
+	"google.golang.org/appengine/v2/blobstore"
+	"google.golang.org/appengine/v2/log"
+	"google.golang.org/appengine/v2/urlfetch"
+)
+
+func main() {
+	configure()
+	http.HandleFunc("/", Main)
+	appengine.Main()
+}
+
+func newContext(r *http.Request) context.Context {
+	return appengine.NewContext(r)
+}
+
+func logErrorf(ctx context.Context, format string, args ...interface{}) {
+	log.Errorf(ctx, format, args...)
+}
+
+func logWarningf(ctx context.Context, format string, args ...interface{}) {
+	log.Warningf(ctx, format, args...)
+}
+
+// gcsTransport fetches through URL Fetch, with the context of each request.
+func gcsTransport() http.RoundTripper {
+	return urlfetchTransport{}
+}
+
+type urlfetchTransport struct{}
+
+func (urlfetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return (&urlfetch.Transport{Context: req.Context()}).RoundTrip(req)
+}
+
+// streamsBody reports whether object bodies are streamed by sendBlobBody,
+// rather than served by Blobstore.
+func (ctx *HandlerContext) streamsBody() bool {
+	_, ok := ctx.storage.(GCSStorage)
+	return !ok
+}
+
+func (ctx *HandlerContext) sendBlob(metadata http.Header) HttpResult {
+	if _, ok := ctx.storage.(GCSStorage); !ok || ctx.body != nil {
+		return ctx.sendBlobBody(metadata)
+	}
+
+	key, err := blobstore.BlobKeyForFile(ctx.r.Context(), "/gs/"+ctx.objectBucket+ctx.objectName())
+	if err != nil {
+		logErrorf(ctx.r.Context(), "BlobKeyForFile /gs/%s: %v", ctx.objectBucket+ctx.objectName(), err)
+		return HttpResult{Status: http.StatusInternalServerError}
+	}
+
+	if header := ctx.r.Header.Get("Range"); len(header) > 0 {
+		if !checkIfRange(ctx.r, metadata.Get("Etag"), metadata.Get("Last-Modified")) {
+			header = ""
+		}
+		ctx.w.Header().Set("X-AppEngine-BlobRange", header)
+	}
+
+	ctx.w.Header().Set("X-AppEngine-BlobKey", string(key))
+	return HttpResult{}
+}
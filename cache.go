@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache is a concurrency safe, expiring cache.
+//
+// Concurrent loads of the same key are deduplicated.
+// Successful loads are cached for TTL, failed ones for NegativeTTL.
+type Cache[V any] struct {
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry[V]
+	swept   time.Time
+}
+
+type cacheEntry[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	expires time.Time
+}
+
+// Get returns the cached value for key, calling load if it's missing or expired.
+func (c *Cache[V]) Get(key string, load func() (V, error)) (V, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		select {
+		case <-e.done:
+			if now.Before(e.expires) {
+				c.mu.Unlock()
+				return e.value, e.err
+			}
+		default:
+			c.mu.Unlock()
+			<-e.done
+			return e.value, e.err
+		}
+	}
+
+	if c.entries == nil {
+		c.entries = map[string]*cacheEntry[V]{}
+	}
+	c.sweep(now)
+	e := &cacheEntry[V]{done: make(chan struct{})}
+	c.entries[key] = e
+	c.mu.Unlock()
+
+	defer close(e.done)
+	e.value, e.err = load()
+	if e.err == nil {
+		e.expires = time.Now().Add(c.TTL)
+	} else {
+		e.expires = time.Now().Add(c.NegativeTTL)
+	}
+	return e.value, e.err
+}
+
+// Delete removes key from the cache.
+func (c *Cache[V]) Delete(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// sweep removes expired entries, at most once per TTL.
+func (c *Cache[V]) sweep(now time.Time) {
+	if now.Sub(c.swept) < c.TTL {
+		return
+	}
+	c.swept = now
+	for k, e := range c.entries {
+		select {
+		case <-e.done:
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		default:
+		}
+	}
+}
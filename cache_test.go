@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Cache(t *testing.T) {
+	var loads int32
+	c := &Cache[int]{TTL: time.Hour}
+	load := func() (int, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.Get("key", load); v != 42 || err != nil {
+				t.Errorf("got %d %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	c.Get("key", load)
+
+	if loads != 1 {
+		t.Errorf("got %d loads, want 1", loads)
+	}
+
+	c.Delete("key")
+	c.Get("key", load)
+	if loads != 2 {
+		t.Errorf("got %d loads, want 2", loads)
+	}
+}
+
+func Test_Cache_expiry(t *testing.T) {
+	var loads int
+	c := &Cache[int]{TTL: time.Hour, NegativeTTL: 0}
+	fail := func() (int, error) {
+		loads++
+		return 0, errors.New("failed")
+	}
+
+	c.Get("key", fail)
+	c.Get("key", fail)
+	if loads != 2 {
+		t.Errorf("got %d loads, want 2", loads)
+	}
+
+	loads = 0
+	c.NegativeTTL = time.Hour
+	c.Get("other", fail)
+	if _, err := c.Get("other", fail); err == nil || loads != 1 {
+		t.Errorf("got %d loads %v, want 1 cached error", loads, err)
+	}
+}
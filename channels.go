@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChannelConfiguration is a preview channel of a site, listed in the site's
+// hostingPrefix + "channels.json" object, which deploy tooling can update at any time.
+//
+// A channel is served from the live host with "--" and the channel name appended
+// to its first label, as in site--channel-abc123.example.dev for site.example.dev,
+// if the live host is configured to have channels.
+// Its content lives under Prefix, relative to the site, and outside its hostingPrefix
+// (defaulting to the site's hostingPrefix + "channels/" + name).
+// After it Expires, it's either "gone" (410), or it "redirect"s to the live site.
+type ChannelConfiguration struct {
+	Prefix  string    `json:"prefix,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+	Expired string    `json:"expired,omitempty"`
+}
+
+var channels = &Cache[map[string]ChannelConfiguration]{TTL: time.Minute, NegativeTTL: 10 * time.Second}
+
+// splitChannel splits a host into its live host and channel name,
+// if its first label has a "--" separator, and the live host has channels.
+func splitChannel(host string) (live, channel string) {
+	if _, ok := hosts[host]; ok {
+		return host, ""
+	}
+	live, channel = cutChannel(host)
+	if c, _, _ := lookupHost(live); channel == "" || !c.Channels {
+		return host, ""
+	}
+	return live, channel
+}
+
+// cutChannel cuts a host at the "--" separator of its first label.
+func cutChannel(host string) (live, channel string) {
+	label, rest, dot := strings.Cut(host, ".")
+	site, channel, ok := strings.Cut(label, "--")
+	if !ok || site == "" || channel == "" {
+		return host, ""
+	}
+	if dot {
+		site += "." + rest
+	}
+	return site, channel
+}
+
+// joinChannel is the inverse of cutChannel.
+func joinChannel(host, channel string) string {
+	if channel == "" {
+		return host
+	}
+	label, rest, dot := strings.Cut(host, ".")
+	if dot {
+		rest = "." + rest
+	}
+	return label + "--" + channel + rest
+}
+
+// initChannel moves the context from the live site to its channel,
+// or returns the result for a missing or expired channel.
+func (ctx *HandlerContext) initChannel() HttpResult {
+	site := ctx.site()
+	list, err := channels.Get(site, func() (map[string]ChannelConfiguration, error) {
+		list, err := loadChannels(context.WithoutCancel(ctx.r.Context()), ctx.storage, ctx.bucket, ctx.prefix)
+		if err != nil {
+			logErrorf(ctx.r.Context(), "GET %s: %v", site+hostingPrefix+"channels.json", err)
+		}
+		return list, err
+	})
+	if err != nil {
+		return HttpResult{Status: http.StatusInternalServerError}
+	}
+
+	c, ok := list[ctx.channel]
+	if !ok {
+		return HttpResult{Status: http.StatusNotFound, Message: "Channel not found"}
+	}
+	if !c.Expires.IsZero() && !time.Now().Before(c.Expires) {
+		if c.Expired == "redirect" {
+			return HttpResult{Status: http.StatusFound, Location: ctx.getScheme() + "://" + ctx.live + ctx.r.URL.RequestURI()}
+		}
+		return HttpResult{Status: http.StatusGone, Message: "Channel expired"}
+	}
+
+	ctx.local = site
+	if c.Prefix != "" {
+		ctx.prefix += "/" + strings.Trim(c.Prefix, "/")
+	} else {
+		ctx.prefix += hostingPrefix + "channels/" + ctx.channel
+	}
+	ctx.objectBucket, ctx.objectPrefix = ctx.bucket, ctx.prefix
+	return HttpResult{}
+}
+
+func loadChannels(ctx context.Context, storage Storage, bucket, prefix string) (map[string]ChannelConfiguration, error) {
+	res, err := storage.Open(ctx, bucket, prefix+hostingPrefix+"channels.json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(http.StatusText(res.StatusCode))
+	}
+
+	var list map[string]ChannelConfiguration
+	file := hostingPrefix + "channels.json"
+	if err := decodeStrict(res.Body, &list); err != nil {
+		return nil, &ConfigError{File: file, Site: bucket + prefix, Err: err}
+	}
+
+	var keys []string
+	for name := range list {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, name := range keys {
+		if err := list[name].validate(name); err != nil {
+			errs = append(errs, &ConfigError{File: file, Site: bucket + prefix, Rule: name, Err: err})
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (c ChannelConfiguration) validate(name string) error {
+	if name == "" || strings.ContainsAny(name, "./: ") || name != strings.ToLower(name) {
+		return errors.New("invalid channel name")
+	}
+	for _, segment := range strings.Split(strings.Trim(c.Prefix, "/"), "/") {
+		if c.Prefix != "" && (segment == "" || segment == "." || segment == ".." || segment == strings.Trim(hostingPrefix, "/")) {
+			return fmt.Errorf("prefix %q: invalid prefix", c.Prefix)
+		}
+	}
+	switch c.Expired {
+	case "", "gone", "redirect":
+	default:
+		return fmt.Errorf("expired %q: must be gone or redirect", c.Expired)
+	}
+	return nil
+}
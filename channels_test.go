@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_cutChannel(t *testing.T) {
+	tests := []struct {
+		host    string
+		live    string
+		channel string
+	}{
+		{"example.com", "example.com", ""},
+		{"site--pr-12.example.dev", "site.example.dev", "pr-12"},
+		{"site--a--b.example.dev", "site.example.dev", "a--b"},
+		{"--pr.example.dev", "--pr.example.dev", ""},
+		{"site--.example.dev", "site--.example.dev", ""},
+		{"localhost--pr", "localhost", "pr"},
+	}
+	for _, tt := range tests {
+		live, channel := cutChannel(tt.host)
+		if live != tt.live || channel != tt.channel {
+			t.Errorf("cutChannel(%q) = %q, %q, want %q, %q", tt.host, live, channel, tt.live, tt.channel)
+		}
+		if got := joinChannel(live, channel); got != tt.host {
+			t.Errorf("joinChannel(%q, %q) = %q, want %q", live, channel, got, tt.host)
+		}
+	}
+}
+
+func Test_channels(t *testing.T) {
+	hosts = map[string]HostConfiguration{
+		"*.example.dev":       {Bucket: "{label}.example.dev", Channels: true},
+		"www.example.dev":     {Redirect: "site.example.dev", Channels: true},
+		"a--b.example.dev":    {Bucket: "dashes"},
+		"*.sites.example.dev": {Bucket: "sites", Prefix: "{label}", Channels: true},
+	}
+	defer func() { hosts = map[string]HostConfiguration{} }()
+
+	setStorage(t, memStorage{
+		"site.example.dev/404.html":                          "live missing",
+		"site.example.dev/index.html":                        "live",
+		"site.example.dev/.hosting/channels/pr-1/index.html": "pr-1",
+		"site.example.dev/.hosting/channels/pr-1/404.html":   "pr-1 missing",
+		"site.example.dev/.hosting/channels.json":            `{"pr-1": {}, "pr-2": {"prefix": "previews/pr-2/"}, "old": {"expires": "2020-01-01T00:00:00Z"}, "moved": {"expires": "2020-01-01T00:00:00Z", "expired": "redirect"}}`,
+		"site.example.dev/previews/pr-2/index.html":          "pr-2",
+		"x--y.example.org/index.html":                        "x--y",
+		"other.example.dev/.hosting/channels.json":           `{"pr": {"bucket": "secrets"}}`,
+		"dashes/index.html":                                  "dashes",
+		"sites/blog/.hosting/channels.json":                  `{"draft": {"prefix": "/drafts"}}`,
+		"sites/blog/drafts/index.html":                       "blog draft",
+		"sites/blog-draft/index.html":                        "other site",
+		"sites/site/.hosting/channels.json":                  `{"a": {"prefix": "drafts/.hosting"}}`,
+		"sites/up/.hosting/channels.json":                    `{"b": {"prefix": "../blog"}}`,
+		"invalid.example.dev/.hosting/channels.json":         `{"pr": {"expired": "never"}}`,
+	})
+
+	tests := []struct {
+		target   string
+		status   int
+		location string
+		body     string
+	}{
+		{"http://site.example.dev/", http.StatusOK, "", "live"},
+		{"http://site--pr-1.example.dev/", http.StatusOK, "", "pr-1"},
+		{"http://SITE--PR-1.example.dev:8080/", http.StatusOK, "", "pr-1"},
+		{"http://www--pr-1.example.dev/", http.StatusMovedPermanently, "http://site--pr-1.example.dev/", ""},
+		{"http://site--pr-1.example.dev/missing", http.StatusNotFound, "", "pr-1 missing"},
+		{"http://site--pr-2.example.dev/", http.StatusOK, "", "pr-2"},
+		{"http://site--pr-3.example.dev/", http.StatusNotFound, "", ""},
+		{"http://site--old.example.dev/", http.StatusGone, "", ""},
+		{"http://site--moved.example.dev/about?x=1", http.StatusFound, "http://site.example.dev/about?x=1", ""},
+		{"http://site.example.dev/.hosting/channels/pr-1/index.html", http.StatusNotFound, "", "live missing"},
+		{"http://a--b.example.dev/", http.StatusOK, "", "dashes"},
+		{"http://blog--draft.sites.example.dev/", http.StatusOK, "", "blog draft"},
+		{"http://up--b.sites.example.dev/", http.StatusInternalServerError, "", ""},
+		{"http://site--a.sites.example.dev/", http.StatusInternalServerError, "", ""},
+		{"http://invalid--pr.example.dev/", http.StatusInternalServerError, "", ""},
+		{"http://other--pr.example.dev/", http.StatusInternalServerError, "", ""},
+		{"http://x--y.example.org/", http.StatusOK, "", "x--y"},
+	}
+
+	for _, tt := range tests {
+		w, res := serve("GET", tt.target)
+		body := w.Body.String()
+		if res.Status != tt.status || res.Location != tt.location || body != tt.body {
+			t.Errorf("GET %s: got %d %q %q, want %d %q %q", tt.target, res.Status, res.Location, body, tt.status, tt.location, tt.body)
+		}
+	}
+}
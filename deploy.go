@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deployer publishes a local directory as a release of a site,
+// a bucket or a prefix in a bucket, through the Cloud Storage XML API.
+//
+// Files are uploaded once, by hash, to the site's hostingPrefix + "blobs/",
+// skipping blobs that already exist. The release's Manifest, which includes
+// the site's firebase.json and any Cache-Control from its headers, is then uploaded,
+// and published by updating release.json.
+//
+// Previous releases stay available to roll back to, unless Keep is set:
+// then only the Keep most recent manifest releases are kept,
+// and blobs none of them reference are deleted.
+type Deployer struct {
+	Endpoint string
+	Client   *http.Client
+	Bucket   string
+	Prefix   string
+	Version  string
+	Keep     int
+	DryRun   bool
+}
+
+type deployFile struct {
+	name string
+	hash string
+	md5  []byte
+	size int64
+}
+
+// Deploy validates config, the site's firebase.json (if any), and deploys public,
+// which defaults to the configured public directory.
+func (d *Deployer) Deploy(ctx context.Context, public string, config []byte) error {
+	if d.Prefix != "" {
+		d.Prefix = "/" + strings.Trim(d.Prefix, "/")
+	}
+	release := ReleaseConfiguration{Version: d.Version, Manifest: true}
+	if release.Version == "" {
+		release.Version = time.Now().UTC().Format("20060102-150405")
+	}
+	if err := release.validate(); err != nil {
+		return err
+	}
+
+	var hosting FirebaseConfiguration
+	if config != nil {
+		var err error
+		hosting, err = parseFirebase(ctx, bytes.NewReader(config), "firebase.json", d.Bucket+d.Prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	if public == "" {
+		public = hosting.Public
+	}
+	if public == "" {
+		public = "public"
+	}
+
+	local, err := d.walk(public, hosting.Ignore)
+	if err != nil {
+		return err
+	}
+	if config != nil {
+		local[hostingPrefix+"firebase.json"] = newDeployFile(config)
+	}
+
+	manifestName := release.prefix() + ".json"
+	if found, err := d.exists(ctx, manifestName); err != nil {
+		return err
+	} else if found {
+		return fmt.Errorf("%s: release %s already exists", d.Bucket+d.Prefix, release.Version)
+	}
+	blobs, err := d.list(ctx, hostingPrefix+"blobs/")
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for p := range local {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	manifest := Manifest{Files: map[string]ManifestFile{}}
+	var uploaded int
+	for _, p := range paths {
+		file := local[p]
+		contentType, err := file.contentType(p, config)
+		if err != nil {
+			return err
+		}
+		header := http.Header{}
+		hosting.processHeaders(p, header)
+		manifest.Files[p] = ManifestFile{Hash: file.hash, Size: file.size, Type: contentType, CacheControl: header.Get("Cache-Control")}
+		if _, ok := blobs[file.hash]; ok {
+			continue
+		}
+		blobs[file.hash] = time.Now()
+
+		if err := d.uploadBlob(ctx, file, config, contentType); err != nil {
+			return err
+		}
+		uploaded++
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := d.upload(ctx, manifestName, bytes.NewReader(data), md5Sum(data), "application/json", ""); err != nil {
+		return err
+	}
+	data, err = json.Marshal(release)
+	if err != nil {
+		return err
+	}
+	if err := d.upload(ctx, hostingPrefix+"release.json", bytes.NewReader(data), md5Sum(data), "application/json", "no-cache"); err != nil {
+		return err
+	}
+
+	log.Printf("%s: release %s, %d files, %d uploaded", d.Bucket+d.Prefix, release.Version, len(paths), uploaded)
+
+	if d.Keep > 0 {
+		return d.prune(ctx, release.Version, &manifest, blobs)
+	}
+	return nil
+}
+
+// prune deletes all but the Keep most recent manifest releases, always keeping current,
+// then the blobs that no kept release references.
+func (d *Deployer) prune(ctx context.Context, current string, manifest *Manifest, blobs map[string]time.Time) error {
+	objects, err := d.list(ctx, hostingPrefix+"releases/")
+	if err != nil {
+		return err
+	}
+
+	var versions []string
+	for name := range objects {
+		version, ok := strings.CutSuffix(name, ".json")
+		if ok && version != current && !strings.Contains(version, "/") {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		a, b := objects[versions[i]+".json"], objects[versions[j]+".json"]
+		return a.After(b) || a.Equal(b) && versions[i] > versions[j]
+	})
+	kept := versions[:min(d.Keep-1, len(versions))]
+	stale := versions[len(kept):]
+
+	referenced := map[string]bool{}
+	for _, file := range manifest.Files {
+		referenced[file.Hash] = true
+	}
+	for _, version := range kept {
+		manifest, err := d.manifest(ctx, version)
+		if err != nil {
+			return err
+		}
+		for _, file := range manifest.Files {
+			referenced[file.Hash] = true
+		}
+	}
+
+	for _, version := range stale {
+		if err := d.delete(ctx, hostingPrefix+"releases/"+version+".json"); err != nil {
+			return err
+		}
+	}
+	var hashes []string
+	for hash := range blobs {
+		if !referenced[hash] {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		if err := d.delete(ctx, hostingPrefix+"blobs/"+hash); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("%s: %d releases kept, %d releases and %d blobs deleted", d.Bucket+d.Prefix, len(kept)+1, len(stale), len(hashes))
+	return nil
+}
+
+// manifest reads the manifest of a release of the site.
+func (d *Deployer) manifest(ctx context.Context, version string) (*Manifest, error) {
+	name := hostingPrefix + "releases/" + version + ".json"
+	req, err := http.NewRequestWithContext(ctx, "GET", d.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", req.URL.Path, http.StatusText(res.StatusCode))
+	}
+	var manifest Manifest
+	if err := decodeStrict(res.Body, &manifest); err != nil {
+		return nil, &ConfigError{File: name, Site: d.Bucket + d.Prefix, Err: err}
+	}
+	return &manifest, manifest.validate(name, d.Bucket+d.Prefix)
+}
+
+// newDeployFile is a file with data, but no name, not read from public.
+func newDeployFile(data []byte) deployFile {
+	hash := sha256.Sum256(data)
+	return deployFile{hash: hex.EncodeToString(hash[:]), md5: md5Sum(data), size: int64(len(data))}
+}
+
+func md5Sum(data []byte) []byte {
+	sum := md5.Sum(data)
+	return sum[:]
+}
+
+// uploadBlob uploads the file, or data if the file has no name, as a blob.
+func (d *Deployer) uploadBlob(ctx context.Context, file deployFile, data []byte, contentType string) error {
+	var body io.Reader = bytes.NewReader(data)
+	if file.name != "" {
+		f, err := os.Open(file.name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		body = f
+	}
+	return d.upload(ctx, hostingPrefix+"blobs/"+file.hash, body, file.md5, contentType, "")
+}
+
+// contentType is the type of the file, by extension of p, or by its content.
+func (f deployFile) contentType(p string, data []byte) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(p)); contentType != "" {
+		return contentType, nil
+	}
+	if f.name != "" {
+		file, err := os.Open(f.name)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+
+		var buf [512]byte
+		n, err := io.ReadFull(file, buf[:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		data = buf[:n]
+	}
+	return http.DetectContentType(data), nil
+}
+
+// walk hashes the files in public, by URL path, skipping those that match ignore.
+func (d *Deployer) walk(public string, ignore []string) (map[string]deployFile, error) {
+	var patterns []*regexp.Regexp
+	for i, glob := range ignore {
+		re, err := compileSource(glob, "")
+		if err != nil {
+			return nil, &ConfigError{File: "firebase.json", Site: d.Bucket + d.Prefix, Rule: fmt.Sprintf("ignore[%d]", i), Err: err}
+		}
+		patterns = append(patterns, re)
+	}
+
+	files := map[string]deployFile{}
+	err := filepath.WalkDir(public, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(public, name)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		p := "/" + filepath.ToSlash(rel)
+		if entry.IsDir() {
+			p += "/"
+		}
+		if strings.HasPrefix(p, hostingPrefix) || matchAny(patterns, strings.TrimSuffix(p, "/")) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		hash, sum := sha256.New(), md5.New()
+		size, err := io.Copy(io.MultiWriter(hash, sum), f)
+		if err != nil {
+			return err
+		}
+		files[p] = deployFile{name: name, hash: hex.EncodeToString(hash.Sum(nil)), md5: sum.Sum(nil), size: size}
+		return nil
+	})
+	return files, err
+}
+
+func matchAny(patterns []*regexp.Regexp, p string) bool {
+	for _, re := range patterns {
+		if re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the names of the site's objects under dir, a directory of the site,
+// relative to dir, with their modification times.
+func (d *Deployer) list(ctx context.Context, dir string) (map[string]time.Time, error) {
+	prefix := strings.TrimPrefix(d.Prefix+dir, "/")
+
+	objects := map[string]time.Time{}
+	query := url.Values{"prefix": {prefix}}
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", d.endpoint()+"/"+d.Bucket+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := d.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			IsTruncated bool
+			NextMarker  string
+			Contents    []struct {
+				Key          string
+				LastModified time.Time
+			}
+		}
+		if res.StatusCode == http.StatusOK {
+			err = xml.NewDecoder(res.Body).Decode(&list)
+		} else {
+			err = errors.New(http.StatusText(res.StatusCode))
+		}
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %w", d.Bucket+d.Prefix+dir, err)
+		}
+
+		for _, c := range list.Contents {
+			objects[strings.TrimPrefix(c.Key, prefix)] = c.LastModified
+		}
+		if !list.IsTruncated || len(list.Contents) == 0 {
+			return objects, nil
+		}
+		if list.NextMarker == "" {
+			list.NextMarker = list.Contents[len(list.Contents)-1].Key
+		}
+		query.Set("marker", list.NextMarker)
+	}
+}
+
+// exists reports whether the site has an object at p.
+func (d *Deployer) exists(ctx context.Context, p string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", d.objectURL(p), nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("HEAD %s: %s", req.URL.Path, http.StatusText(res.StatusCode))
+}
+
+func (d *Deployer) upload(ctx context.Context, p string, body io.Reader, sum []byte, contentType, cacheControl string) error {
+	log.Printf("upload %s%s (%s)", d.Bucket+d.Prefix, p, contentType)
+	if d.DryRun {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", d.objectURL(p), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+	if cacheControl != "" {
+		req.Header.Set("Cache-Control", cacheControl)
+	}
+	return d.do(req)
+}
+
+func (d *Deployer) delete(ctx context.Context, p string) error {
+	log.Printf("delete %s%s", d.Bucket+d.Prefix, p)
+	if d.DryRun {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", d.objectURL(p), nil)
+	if err != nil {
+		return err
+	}
+	return d.do(req)
+}
+
+func (d *Deployer) do(req *http.Request) error {
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 == 2 || req.Method == "DELETE" && res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, http.StatusText(res.StatusCode))
+}
+
+func (d *Deployer) objectURL(p string) string {
+	return d.endpoint() + "/" + d.Bucket + (&url.URL{Path: d.Prefix + p}).EscapedPath()
+}
+
+func (d *Deployer) endpoint() string {
+	if d.Endpoint == "" {
+		return "https://storage.googleapis.com"
+	}
+	return strings.TrimSuffix(d.Endpoint, "/")
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeObject struct {
+	content      string
+	contentType  string
+	cacheControl string
+}
+
+// fakeGCS implements enough of the Cloud Storage XML API to deploy to.
+type fakeGCS struct {
+	mu       sync.Mutex
+	objects  map[string]fakeObject
+	modified map[string]time.Time
+	puts     []string
+	deletes  []string
+	clock    int64
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, object, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "GET" && object == "":
+		type contents struct {
+			Key          string
+			LastModified time.Time
+		}
+		var keys []string
+		prefix := bucket + "/" + r.URL.Query().Get("prefix")
+		marker := bucket + "/" + r.URL.Query().Get("marker")
+		for key := range f.objects {
+			if strings.HasPrefix(key, prefix) && key > marker {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		var list struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			IsTruncated bool
+			Contents    []contents
+		}
+		if len(keys) > 2 {
+			keys, list.IsTruncated = keys[:2], true
+		}
+		for _, key := range keys {
+			list.Contents = append(list.Contents, contents{strings.TrimPrefix(key, bucket+"/"), f.modified[key]})
+		}
+		xml.NewEncoder(w).Encode(list)
+	case r.Method == "GET":
+		obj, ok := f.objects[bucket+"/"+object]
+		if !ok {
+			http.NotFound(w, r)
+		}
+		io.WriteString(w, obj.content)
+	case r.Method == "HEAD":
+		if _, ok := f.objects[bucket+"/"+object]; !ok {
+			http.NotFound(w, r)
+		}
+	case r.Method == "PUT":
+		body, _ := io.ReadAll(r.Body)
+		f.objects[bucket+"/"+object] = fakeObject{string(body), r.Header.Get("Content-Type"), r.Header.Get("Cache-Control")}
+		f.puts = append(f.puts, "/"+object)
+		f.clock++
+		f.modified[bucket+"/"+object] = time.Unix(f.clock, 0).UTC()
+	case r.Method == "DELETE":
+		if _, ok := f.objects[bucket+"/"+object]; !ok {
+			http.NotFound(w, r)
+		}
+		delete(f.objects, bucket+"/"+object)
+		f.deletes = append(f.deletes, "/"+object)
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeGCS) storage() memStorage {
+	m := memStorage{}
+	for key, obj := range f.objects {
+		m[key] = obj.content
+	}
+	return m
+}
+
+func sha256Hex(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])
+}
+
+func Test_Deployer(t *testing.T) {
+	public := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html":              "home",
+		"about.html":              "about",
+		"café menu.html":          "menu",
+		"css/site.css":            "body{}",
+		"data":                    "\x00\x01",
+		".env":                    "secret",
+		"node_modules/x/index.js": "x",
+		".hosting/firebase.json":  "{}",
+	} {
+		name = filepath.Join(public, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(name), 0o755)
+		os.WriteFile(name, []byte(content), 0o644)
+	}
+
+	config := []byte(`{"hosting": {
+		"ignore": ["**/.*", "**/node_modules/**"],
+		"headers": [{"source": "**/*.css", "headers": [{"key": "Cache-Control", "value": "max-age=3600"}]}]
+	}}`)
+
+	objects := map[string]fakeObject{
+		"other/index.html":                                 {content: "other"},
+		"sites/index.html":                                 {content: "root"},
+		"sites/site/about.html":                            {content: "about"},
+		"sites/site/.hosting/blobs/" + sha256Hex("about"):  {content: "about"},
+		"sites/site/.hosting/channels.json":                {content: "{}"},
+		"sites/site/.hosting/releases/old/index.html":      {content: "old"},
+		"sites/site-2/index.html":                          {content: "site 2"},
+		"sites/site-2/.hosting/blobs/" + sha256Hex("home"): {content: "home"},
+	}
+	gcs := &fakeGCS{objects: map[string]fakeObject{}, modified: map[string]time.Time{}}
+	for key, obj := range objects {
+		gcs.objects[key] = obj
+	}
+	server := httptest.NewServer(gcs)
+	defer server.Close()
+
+	d := Deployer{Endpoint: server.URL, Client: server.Client(), Bucket: "sites", Prefix: "site/", Version: "v1"}
+	if err := d.Deploy(context.Background(), public, config); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, obj := range objects {
+		if got := gcs.objects[key]; got != obj {
+			t.Errorf("%s: got %q, want %q", key, got, obj)
+		}
+	}
+	want := []string{
+		"/site/.hosting/blobs/" + sha256Hex(string(config)),
+		"/site/.hosting/blobs/" + sha256Hex("menu"),
+		"/site/.hosting/blobs/" + sha256Hex("body{}"),
+		"/site/.hosting/blobs/" + sha256Hex("\x00\x01"),
+		"/site/.hosting/blobs/" + sha256Hex("home"),
+		"/site/.hosting/releases/v1.json",
+		"/site/.hosting/release.json",
+	}
+	if got := strings.Join(gcs.puts, " "); got != strings.Join(want, " ") {
+		t.Errorf("got uploads %s, want %s", got, strings.Join(want, " "))
+	}
+	if got := gcs.objects["sites/site/.hosting/release.json"]; got.cacheControl != "no-cache" {
+		t.Errorf("got release.json Cache-Control %q", got.cacheControl)
+	}
+
+	ctx := context.Background()
+	release, err := loadRelease(ctx, gcs.storage(), "sites", "/site")
+	if err != nil || release != (ReleaseConfiguration{Version: "v1", Manifest: true}) {
+		t.Fatalf("got release %v, %v", release, err)
+	}
+	manifest, err := loadManifest(ctx, gcs.storage(), "sites", "/site", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]struct{ content, contentType, cacheControl string }{
+		"/index.html":             {"home", "text/html; charset=utf-8", ""},
+		"/about.html":             {"about", "text/html; charset=utf-8", ""},
+		"/café menu.html":         {"menu", "text/html; charset=utf-8", ""},
+		"/css/site.css":           {"body{}", "text/css; charset=utf-8", "max-age=3600"},
+		"/data":                   {"\x00\x01", "application/octet-stream", ""},
+		"/.hosting/firebase.json": {string(config), "application/json", ""},
+	}
+	if len(manifest.Files) != len(files) {
+		t.Errorf("got %d files, want %d", len(manifest.Files), len(files))
+	}
+	for p, file := range files {
+		got := manifest.Files[p]
+		if got != (ManifestFile{Hash: sha256Hex(file.content), Size: int64(len(file.content)), Type: file.contentType, CacheControl: file.cacheControl}) {
+			t.Errorf("%s: got %v", p, got)
+		}
+		if blob := gcs.objects["sites/site/.hosting/blobs/"+got.Hash]; blob.content != file.content {
+			t.Errorf("%s: got blob %q, want %q", p, blob.content, file.content)
+		}
+	}
+
+	gcs.puts = nil
+	if err := d.Deploy(context.Background(), public, config); err == nil {
+		t.Error("want error for an existing release")
+	}
+	if len(gcs.puts) != 0 {
+		t.Errorf("got uploads %v, want none", gcs.puts)
+	}
+
+	d.Version = "v2"
+	if err := d.Deploy(context.Background(), public, config); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(gcs.puts, " "); got != "/site/.hosting/releases/v2.json /site/.hosting/release.json" {
+		t.Errorf("got uploads %s", got)
+	}
+
+	gcs.puts = nil
+	for _, version := range []string{"..", "v/3"} {
+		d.Version = version
+		if err := d.Deploy(context.Background(), public, config); err == nil {
+			t.Errorf("want error for version %q", version)
+		}
+	}
+	d.Version = "v3"
+	invalid := []byte(`{"hosting": {"redirects": [{"source": "/a", "destination": "/b", "type": 200}]}}`)
+	if err := d.Deploy(context.Background(), public, invalid); err == nil {
+		t.Error("want error for invalid config")
+	}
+	if len(gcs.puts) != 0 {
+		t.Errorf("got uploads %v, want none", gcs.puts)
+	}
+
+	// Only v1 and v2 reference the home blob.
+	os.WriteFile(filepath.Join(public, "index.html"), []byte("home 3"), 0o644)
+	d.Version, d.Keep = "v3", 2
+	if err := d.Deploy(context.Background(), public, config); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(gcs.deletes, " "); got != "/site/.hosting/releases/v1.json" {
+		t.Errorf("got deletes %s", got)
+	}
+
+	gcs.deletes = nil
+	d.Version, d.Keep = "v4", 1
+	if err := d.Deploy(context.Background(), public, config); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(gcs.deletes, " "); got != "/site/.hosting/releases/v3.json /site/.hosting/releases/v2.json /site/.hosting/blobs/"+sha256Hex("home") {
+		t.Errorf("got deletes %s", got)
+	}
+	for key, obj := range objects {
+		if got := gcs.objects[key]; got != obj {
+			t.Errorf("%s: got %q, want %q after pruning", key, got, obj)
+		}
+	}
+	if _, err := loadManifest(ctx, gcs.storage(), "sites", "/site", "v4"); err != nil {
+		t.Error(err)
+	}
+
+	d = Deployer{Endpoint: server.URL, Client: server.Client(), Bucket: "sites", Version: "v1"}
+	if err := d.Deploy(context.Background(), public, nil); err != nil {
+		t.Fatal(err)
+	}
+	for key, obj := range objects {
+		if got := gcs.objects[key]; got != obj {
+			t.Errorf("%s: got %q, want %q after deploying the root site", key, got, obj)
+		}
+	}
+	if got := gcs.objects["sites/.hosting/release.json"].content; got != `{"version":"v1","manifest":true}` {
+		t.Errorf("got release.json %s", got)
+	}
+
+	gcs.puts = nil
+	d = Deployer{Endpoint: server.URL, Client: server.Client(), Bucket: "missing", DryRun: true}
+	if err := d.Deploy(context.Background(), public, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(gcs.puts) != 0 {
+		t.Errorf("got uploads %v, want none", gcs.puts)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// compressText enables compressing uncompressed text objects on the fly,
+// for clients that accept gzip.
+var compressText = false
+
+// precompressed caches the metadata of precompressed siblings of objects,
+// keyed by the object and its Etag; nil if missing.
+var precompressed = &Cache[http.Header]{TTL: 5 * time.Minute, NegativeTTL: 10 * time.Second}
+
+// precompressedSites are the sites where precompressed siblings were found.
+var precompressedSites = &sync.Map{}
+
+// hasPrecompressed reports whether the site may have a precompressed sibling
+// of the object the client accepts.
+func (ctx *HandlerContext) hasPrecompressed() bool {
+	if _, ok := precompressedSites.Load(ctx.site()); !ok {
+		return false
+	}
+	for _, p := range precompressedExts {
+		if acceptsEncoding(ctx.r, p.encoding) {
+			return true
+		}
+	}
+	return false
+}
+
+// precompressedExts are the extensions of precompressed siblings, in order of preference.
+var precompressedExts = []struct{ encoding, ext string }{{"br", ".br"}, {"gzip", ".gz"}}
+
+// getPrecompressed looks for a precompressed sibling of an uncompressed object
+// (as in app.js.br or app.js.gz) the client accepts.
+// If found, the context moves to the sibling, and its metadata is returned,
+// with the Content-Type of the object.
+func (ctx *HandlerContext) getPrecompressed(metadata http.Header) http.Header {
+	if storedEncoding(metadata) != "identity" || ctx.r.Header.Get("Range") != "" {
+		return nil
+	}
+
+	for _, p := range precompressedExts {
+		if !acceptsEncoding(ctx.r, p.encoding) {
+			continue
+		}
+
+		bucket, name := ctx.objectBucket, ctx.objectName()+p.ext
+		header, err := precompressed.Get(bucket+name+" "+metadata.Get("Etag"), func() (http.Header, error) {
+			res, err := ctx.storage.Stat(context.WithoutCancel(ctx.r.Context()), bucket, name)
+			if err != nil {
+				logErrorf(ctx.r.Context(), "HEAD %s: %v", bucket+name, err)
+				return nil, err
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				return nil, nil
+			}
+			return res.Header, nil
+		})
+		if err != nil || header == nil {
+			continue
+		}
+
+		ctx.tracef("precompressed %s: %s", p.encoding, bucket+name)
+		precompressedSites.Store(ctx.site(), true)
+		ctx.closeBody()
+		ctx.object += p.ext
+		header = header.Clone()
+		header.Set("Content-Type", metadata.Get("Content-Type"))
+		header.Set("x-goog-stored-content-encoding", p.encoding)
+		return header
+	}
+	return nil
+}
+
+// storedEncoding is the content coding an object is stored with.
+func storedEncoding(metadata http.Header) string {
+	if enc := metadata.Get("x-goog-stored-content-encoding"); enc != "" {
+		return strings.ToLower(enc)
+	}
+	return "identity"
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding allows the content coding.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	if coding == "identity" {
+		return true
+	}
+
+	star := false
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, part := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(part, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			accept := true
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				v, err := strconv.ParseFloat(q, 64)
+				accept = err == nil && v > 0
+			}
+			switch name {
+			case coding, "x-" + coding:
+				return accept
+			case "*":
+				star = accept
+			}
+		}
+	}
+	return star
+}
+
+// compressible reports whether an uncompressed object should be compressed on the fly.
+func (ctx *HandlerContext) compressible(contentType string) bool {
+	if !compressText || ctx.r.Header.Get("Range") != "" || !acceptsEncoding(ctx.r, "gzip") {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml", "application/wasm", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// transcode copies body, encoded as received, to w, encoded as sent.
+func transcode(w io.Writer, body io.Reader, received, sent string) error {
+	switch {
+	case received == sent:
+		_, err := io.Copy(w, body)
+		return err
+
+	case received == "gzip" && sent == "identity":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, zr)
+		return err
+
+	case received == "identity" && sent == "gzip":
+		zw := gzip.NewWriter(w)
+		if _, err := io.Copy(zw, body); err != nil {
+			return err
+		}
+		return zw.Close()
+
+	default:
+		return errUnsupportedEncoding
+	}
+}
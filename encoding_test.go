@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// gzipStorage stores objects gzipped, and decompresses them
+// unless gzip is accepted, like Cloud Storage.
+type gzipStorage struct {
+	memStorage
+}
+
+func (s gzipStorage) Stat(ctx context.Context, bucket, object string) (*http.Response, error) {
+	res, err := s.Open(ctx, bucket, object, nil)
+	if err == nil {
+		res.Body = http.NoBody
+	}
+	return res, err
+}
+
+func (s gzipStorage) Open(ctx context.Context, bucket, object string, header http.Header) (*http.Response, error) {
+	res, err := s.memStorage.Open(ctx, bucket, object, header)
+	if err != nil || res.StatusCode != http.StatusOK || object == "/404.html" {
+		return res, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	io.Copy(zw, res.Body)
+	zw.Close()
+
+	res.Header.Set("x-goog-stored-content-encoding", "gzip")
+	res.Header.Set("x-goog-stored-content-length", strconv.Itoa(buf.Len()))
+	if header.Get("Accept-Encoding") == "gzip" {
+		res.Header.Set("Content-Encoding", "gzip")
+		res.Body = io.NopCloser(&buf)
+	}
+	return res, nil
+}
+
+func Test_acceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		coding string
+		want   bool
+	}{
+		{"", "gzip", false},
+		{"", "identity", true},
+		{"gzip", "gzip", true},
+		{"GZIP, br", "br", true},
+		{"deflate, gzip;q=1.0, *;q=0.5", "gzip", true},
+		{"gzip;q=0", "gzip", false},
+		{"*", "br", true},
+		{"*, br;q=0", "br", false},
+		{"x-gzip", "gzip", true},
+		{"compress", "gzip", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tt.header)
+		if got := acceptsEncoding(r, tt.coding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
+
+func Test_contentEncoding(t *testing.T) {
+	plain := memStorage{
+		"example.com/index.html": "hello, hello, hello, hello",
+		"example.com/image.png":  "png",
+		"example.com/404.html":   "missing",
+	}
+
+	tests := []struct {
+		storage  Storage
+		compress bool
+		accept   string
+		encoding string
+		length   string
+	}{
+		{gzipStorage{plain}, false, "gzip, br", "gzip", "*"},
+		{gzipStorage{plain}, false, "br", "", ""},
+		{gzipStorage{plain}, false, "", "", ""},
+		{plain, false, "gzip", "", "26"},
+		{plain, true, "gzip", "gzip", ""},
+		{plain, true, "", "", "26"},
+	}
+
+	for _, tt := range tests {
+		setStorage(t, tt.storage)
+		compressText = tt.compress
+
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		w := httptest.NewRecorder()
+		StaticWebsiteHandler(w, r)
+
+		body := w.Body.Bytes()
+		if tt.length == "*" {
+			tt.length = strconv.Itoa(len(body))
+		}
+		if tt.encoding == "gzip" {
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ = io.ReadAll(zr)
+		}
+
+		if w.Code != http.StatusOK || string(body) != plain["example.com/index.html"] {
+			t.Errorf("%T %q: got %d %q", tt.storage, tt.accept, w.Code, body)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%T %q: got Content-Encoding %q, want %q", tt.storage, tt.accept, got, tt.encoding)
+		}
+		if got := w.Header().Get("Content-Length"); got != tt.length {
+			t.Errorf("%T %q: got Content-Length %q, want %q", tt.storage, tt.accept, got, tt.length)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%T %q: got Vary %q", tt.storage, tt.accept, got)
+		}
+	}
+	compressText = false
+}
+
+func Test_compressible(t *testing.T) {
+	compressText = true
+	defer func() { compressText = false }()
+
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"text/html; charset=utf-8", true},
+		{"application/javascript", true},
+		{"application/manifest+json", true},
+		{"image/svg+xml", true},
+		{"image/png", false},
+		{"application/octet-stream", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		ctx := HandlerContext{r: httptest.NewRequest("GET", "/", nil)}
+		ctx.r.Header.Set("Accept-Encoding", "gzip")
+		if got := ctx.compressible(tt.contentType); got != tt.want {
+			t.Errorf("compressible(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func Test_precompressed(t *testing.T) {
+	counter := &countingStorage{Storage: memStorage{
+		"example.com/app.js":       "plain",
+		"example.com/app.js.br":    "brotli",
+		"example.com/app.js.gz":    "gzip",
+		"example.com/page.html":    "page",
+		"example.com/style.css":    "css",
+		"example.com/style.css.gz": "css gzip",
+	}}
+	setStorage(t, counter)
+	precompressed.TTL = time.Minute
+
+	tests := []struct {
+		target   string
+		accept   string
+		encoding string
+		body     string
+		stats    int
+	}{
+		// Once the site has siblings, objects are no longer opened before looking for them.
+		{"/app.js", "gzip, deflate, br", "br", "brotli", 1},
+		{"/app.js", "gzip, deflate, br", "br", "brotli", 1},
+		{"/app.js", "gzip", "gzip", "gzip", 2},
+		{"/app.js", "", "", "plain", 0},
+		{"/page.html", "gzip, br", "", "page", 3},
+		{"/page.html", "gzip, br", "", "page", 1},
+		{"/style.css", "br, gzip", "gzip", "css gzip", 3},
+	}
+
+	for _, tt := range tests {
+		counter.stats = 0
+		r := httptest.NewRequest("GET", "http://example.com"+tt.target, nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		w := httptest.NewRecorder()
+		StaticWebsiteHandler(w, r)
+
+		encoding := w.Header().Get("Content-Encoding")
+		if w.Code != http.StatusOK || encoding != tt.encoding || w.Body.String() != tt.body || counter.stats != tt.stats {
+			t.Errorf("GET %s %q: got %d %q %q (%d HEAD), want %q %q (%d HEAD)", tt.target, tt.accept, w.Code, encoding, w.Body, counter.stats, tt.encoding, tt.body, tt.stats)
+		}
+	}
+}
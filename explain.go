@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+)
+
+type traceKey struct{}
+
+// withTrace returns a context for which StaticWebsiteHandler
+// reports each decision it makes to trace.
+func withTrace(ctx context.Context, trace func(string)) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+func (ctx *HandlerContext) tracef(format string, args ...interface{}) {
+	if ctx.trace != nil {
+		ctx.trace(fmt.Sprintf(format, args...))
+	}
+}
+
+// traceRules reports the rules of a matcher set that match path:
+// the first, or all of them.
+func (ctx *HandlerContext) traceRules(kind string, m *matcherSet, path string, all bool, describe func(i int) (source, regex, action string)) {
+	if ctx.trace == nil {
+		return
+	}
+	for i := m.first(path, 0); i >= 0; i = m.first(path, i+1) {
+		source, regex, action := describe(i)
+		ctx.tracef("%s[%d] %s: %s", kind, i, describeSource(source, regex), action)
+		if !all {
+			break
+		}
+	}
+}
+
+func (ctx *HandlerContext) traceRedirect(path string) {
+	ctx.traceRules("redirects", ctx.firebase.getMatchers().redirects, path, false, func(i int) (string, string, string) {
+		r := ctx.firebase.Redirects[i]
+		return r.Source, r.Regex, "redirect to " + r.Destination
+	})
+}
+
+func (ctx *HandlerContext) traceRewrite(path string) {
+	ctx.traceRules("rewrites", ctx.firebase.getMatchers().rewrites, path, false, func(i int) (string, string, string) {
+		r := ctx.firebase.Rewrites[i]
+		if r.Proxy != "" {
+			return r.Source, r.Regex, "proxy to " + r.Proxy
+		}
+		return r.Source, r.Regex, "rewrite to " + r.Destination
+	})
+}
+
+func (ctx *HandlerContext) traceHeaders(path string) {
+	ctx.traceRules("headers", ctx.firebase.getMatchers().headers, path, true, func(i int) (string, string, string) {
+		h := ctx.firebase.Headers[i]
+		var keys []string
+		for _, header := range h.Headers {
+			keys = append(keys, header.Key)
+		}
+		return h.Source, h.Regex, "set " + strings.Join(keys, ", ")
+	})
+}
+
+// explain serves r, writing to out the trace of how it was resolved,
+// and the status and headers of the response.
+func explain(out io.Writer, r *http.Request) {
+	fmt.Fprintf(out, "%s %s\n", r.Method, r.URL)
+
+	w := httptest.NewRecorder()
+	r = r.WithContext(withTrace(r.Context(), func(s string) {
+		fmt.Fprintf(out, "  %s\n", s)
+	}))
+	Main(w, r)
+
+	res := w.Result()
+	fmt.Fprintf(out, "%s %s\n", res.Proto, res.Status)
+
+	var keys []string
+	for key := range res.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range res.Header[key] {
+			fmt.Fprintf(out, "%s: %s\n", key, value)
+		}
+	}
+	fmt.Fprintf(out, "\n(%d bytes)\n", w.Body.Len())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_explain(t *testing.T) {
+	setStorage(t, memStorage{
+		"example.com/404.html":        "missing",
+		"example.com/docs/index.html": "docs",
+		"example.com/.hosting/firebase.json": `{"hosting": {
+			"cleanUrls": true,
+			"redirects": [{"source": "/old", "destination": "/docs/"}],
+			"rewrites": [{"source": "/app/**", "destination": "/app.html"}],
+			"headers": [
+				{"source": "**", "headers": [{"key": "Cache-Control", "value": "no-cache"}]},
+				{"regex": "^/docs/.*", "headers": [{"key": "X-Docs", "value": "1"}]}
+			]
+		}}`,
+	})
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"http://example.com/old", []string{
+			`  redirects[0] source "/old": redirect to /docs/`,
+			"HTTP/1.1 301 Moved Permanently",
+		}},
+		{"http://example.com/docs/index.html", []string{
+			"  clean URL redirect to /docs/",
+			"Location: /docs/",
+		}},
+		{"http://example.com/docs/", []string{
+			"  GET example.com/docs/: 404",
+			"  HEAD example.com/docs/index.html: 200",
+			`  headers[0] source "**": set Cache-Control`,
+			`  headers[1] regex "^/docs/.*": set X-Docs`,
+			"HTTP/1.1 200 OK",
+		}},
+		{"http://example.com/app/x", []string{
+			"  HEAD example.com/app/x.html: 404",
+			`  rewrites[0] source "/app/**": rewrite to /app.html`,
+			"  HEAD example.com/app.html: 404",
+			"  not found page example.com/404.html",
+			"HTTP/1.1 404 Not Found",
+		}},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		explain(&out, httptest.NewRequest("GET", tt.target, nil))
+		for _, line := range tt.want {
+			if !strings.Contains(out.String(), line+"\n") {
+				t.Errorf("explain %s: missing %q in:\n%s", tt.target, line, out.String())
+			}
+		}
+	}
+}
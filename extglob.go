@@ -258,3 +258,23 @@ func CompileTemplate(template string) string {
 
 	return string(result)
 }
+
+func templateNames(template string) []string {
+	var names []string
+	compiled := CompileTemplate(template)
+
+	for pos := 0; pos < len(compiled); pos++ {
+		if compiled[pos] != '$' {
+			continue
+		}
+		pos += 1
+		if pos < len(compiled) && compiled[pos] == '{' {
+			if end := strings.IndexByte(compiled[pos:], '}'); end >= 0 {
+				names = append(names, compiled[pos+1:pos+end])
+				pos += end
+			}
+		}
+	}
+
+	return names
+}
@@ -1,74 +1,289 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
 	"strings"
 )
 
+// hostingPrefix is where a bucket keeps its own hosting configuration,
+// which is never served.
+const hostingPrefix = "/.hosting/"
+
+// isHosting reports whether an object of a site at prefix is under its hostingPrefix,
+// or outside the site, once its full name is unescaped and cleaned, as storage may do.
+func isHosting(prefix, object string) bool {
+	name, err := url.PathUnescape(prefix + object)
+	if err != nil {
+		return true
+	}
+	rel, ok := strings.CutPrefix(path.Clean("/"+name)+"/", prefix+"/")
+	return !ok || strings.HasPrefix("/"+rel, hostingPrefix)
+}
+
 type FirebaseConfiguration struct {
 	Redirects []struct {
-		Source      string `json:"source"`
+		Source      string `json:"source,omitempty"`
+		Regex       string `json:"regex,omitempty"`
 		Destination string `json:"destination"`
 		Type        int    `json:"type,omitempty"`
 	} `json:"redirects"`
 	Rewrites []struct {
-		Source      string `json:"source"`
-		Destination string `json:"destination"`
+		Source      string `json:"source,omitempty"`
+		Regex       string `json:"regex,omitempty"`
+		Destination string `json:"destination,omitempty"`
+		Proxy       string `json:"proxy,omitempty"`
 	} `json:"rewrites"`
 	Headers []struct {
-		Source  string `json:"source"`
+		Source  string `json:"source,omitempty"`
+		Regex   string `json:"regex,omitempty"`
 		Headers []struct {
 			Key   string `json:"key"`
 			Value string `json:"value"`
 		} `json:"headers"`
 	} `json:"headers"`
-	CleanUrls     bool  `json:"cleanUrls"`
-	TrailingSlash *bool `json:"trailingSlash"`
+	CleanUrls     bool     `json:"cleanUrls"`
+	TrailingSlash *bool    `json:"trailingSlash"`
+	Public        string   `json:"public,omitempty"`
+	Ignore        []string `json:"ignore,omitempty"`
+
+	matchers *firebaseMatchers
 }
 
-func (c FirebaseConfiguration) processRedirects(path string) (int, string) {
-	for _, redirect := range c.Redirects {
-		pattern, err := CompileExtGlob("/" + strings.TrimPrefix(redirect.Source, "/"))
+// initFirebase loads the hosting configuration of the site from its
+// hostingPrefix + "firebase.json" object, falling back to the local firebase.json
+// (of the live site, for a channel or release).
+func (ctx *HandlerContext) initFirebase() (err error) {
+	local := ctx.site()
+	if ctx.local != "" {
+		local = ctx.local
+	}
+	ctx.firebase, err = firebases.Get(ctx.site(), func() (FirebaseConfiguration, error) {
+		config, err := loadFirebase(context.WithoutCancel(ctx.r.Context()), ctx.storage, ctx.bucket, ctx.prefix, local)
 		if err != nil {
-			return http.StatusInternalServerError, ""
+			logErrorf(ctx.r.Context(), "GET %s: %v", ctx.site()+hostingPrefix+"firebase.json", err)
 		}
-		if pattern.MatchString(path) {
-			dest := redirect.Destination
-			if pattern.NumSubexp() > 0 {
-				dest = pattern.ReplaceAllString(path, CompileTemplate(dest))
-			}
-			if redirect.Type == 0 {
-				return http.StatusMovedPermanently, dest
+		return config, err
+	})
+	return err
+}
+
+func loadFirebase(ctx context.Context, storage Storage, bucket, prefix, local string) (FirebaseConfiguration, error) {
+	res, err := storage.Open(ctx, bucket, prefix+hostingPrefix+"firebase.json", nil)
+	if err != nil {
+		return FirebaseConfiguration{}, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return firebase[local], nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return FirebaseConfiguration{}, errors.New(http.StatusText(res.StatusCode))
+	}
+
+	return parseFirebase(ctx, res.Body, hostingPrefix+"firebase.json", bucket+prefix)
+}
+
+// parseFirebase decodes, validates and compiles a site's own firebase.json.
+// Unknown keys are logged, and ignored.
+func parseFirebase(ctx context.Context, r io.Reader, file, site string) (FirebaseConfiguration, error) {
+	var config struct {
+		Hosting json.RawMessage `json:"hosting"`
+		FirebaseConfiguration
+	}
+
+	unknown, err := decodeConfig(r, &config)
+	if err != nil {
+		return FirebaseConfiguration{}, &ConfigError{File: file, Site: site, Err: err}
+	}
+	if len(config.Hosting) > 0 && string(config.Hosting) != "null" {
+		hosting, err := selectHosting(config.Hosting, site)
+		if err != nil {
+			return FirebaseConfiguration{}, &ConfigError{File: file, Site: site, Err: err}
+		}
+		config.FirebaseConfiguration = FirebaseConfiguration{}
+		more, err := decodeConfig(bytes.NewReader(hosting), &config.FirebaseConfiguration)
+		if err != nil {
+			return FirebaseConfiguration{}, &ConfigError{File: file, Site: site, Err: err}
+		}
+		for _, key := range more {
+			unknown = append(unknown, "hosting."+key)
+		}
+	}
+	for _, key := range unknown {
+		logWarningf(ctx, "%v", unknownKeyError(file, site, key))
+	}
+
+	errs := config.validate(file, site)
+	for i, rewrite := range config.Rewrites {
+		if _, ok := rewriteBucket(rewrite.Destination); ok {
+			errs = append(errs, &ConfigError{
+				File: file, Site: site, Rule: fmt.Sprintf("rewrites[%d]", i),
+				Err: fmt.Errorf("destination %q: gs:// rewrites are only allowed in the app's firebase.json", rewrite.Destination),
+			})
+		}
+		if rewrite.Proxy != "" {
+			errs = append(errs, &ConfigError{
+				File: file, Site: site, Rule: fmt.Sprintf("rewrites[%d]", i),
+				Err: fmt.Errorf("proxy %q: proxy rewrites are only allowed in the app's firebase.json", rewrite.Proxy),
+			})
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return FirebaseConfiguration{}, err
+	}
+	if err := config.FirebaseConfiguration.compile(); err != nil {
+		return FirebaseConfiguration{}, &ConfigError{File: file, Site: site, Err: err}
+	}
+	return config.FirebaseConfiguration, nil
+}
+
+// selectHosting picks the hosting configuration of a site:
+// the hosting object, or the entry of a hosting array
+// that is the only one, or has a site or target that names the site
+// (in full, or by its last path element).
+func selectHosting(hosting json.RawMessage, site string) (json.RawMessage, error) {
+	var entries []json.RawMessage
+	if json.Unmarshal(hosting, &entries) != nil {
+		return hosting, nil
+	}
+	if len(entries) == 1 {
+		return entries[0], nil
+	}
+	for _, entry := range entries {
+		var id struct{ Site, Target string }
+		json.Unmarshal(entry, &id)
+		for _, name := range []string{id.Site, id.Target} {
+			if name != "" && (name == site || name == path.Base(site)) {
+				return entry, nil
 			}
-			return redirect.Type, dest
 		}
 	}
-	return 0, ""
+	return nil, fmt.Errorf("hosting: no entry with a site or target of %q", path.Base(site))
 }
 
-func (c FirebaseConfiguration) processRewrites(path string) string {
-	for _, rewrite := range c.Rewrites {
-		pattern, err := CompileExtGlob("/" + strings.TrimPrefix(rewrite.Source, "/"))
+// compileSource compiles the pattern of a rule,
+// which is either a source glob, or a regex that must match the whole path.
+func compileSource(source, regex string) (*regexp.Regexp, error) {
+	switch {
+	case source != "" && regex != "":
+		return nil, errors.New("both source and regex")
+	case regex != "":
+		return regexp.Compile("^(?:" + regex + ")$")
+	case source != "":
+		return CompileExtGlob("/" + strings.TrimPrefix(source, "/"))
+	default:
+		return nil, errors.New("missing source or regex")
+	}
+}
+
+type firebaseMatchers struct {
+	redirects, rewrites, headers *matcherSet
+}
+
+// compile precompiles the sources of all rules.
+func (c *FirebaseConfiguration) compile() error {
+	var redirects, rewrites, headers []*regexp.Regexp
+	var errs []error
+
+	add := func(patterns *[]*regexp.Regexp, source, regex string) {
+		pattern, err := compileSource(source, regex)
 		if err != nil {
-			return ""
-		}
-		if pattern.MatchString(path) {
-			return rewrite.Destination
+			errs = append(errs, err)
+			pattern = regexp.MustCompile("$.^")
 		}
+		*patterns = append(*patterns, pattern)
+	}
+
+	for _, redirect := range c.Redirects {
+		add(&redirects, redirect.Source, redirect.Regex)
+	}
+	for _, rewrite := range c.Rewrites {
+		add(&rewrites, rewrite.Source, rewrite.Regex)
+	}
+	for _, h := range c.Headers {
+		add(&headers, h.Source, h.Regex)
+	}
+
+	var err error
+	m := &firebaseMatchers{}
+	if m.redirects, err = newMatcherSet(redirects); err != nil {
+		errs = append(errs, fmt.Errorf("redirects: %w", err))
+	}
+	if m.rewrites, err = newMatcherSet(rewrites); err != nil {
+		errs = append(errs, fmt.Errorf("rewrites: %w", err))
+	}
+	if m.headers, err = newMatcherSet(headers); err != nil {
+		errs = append(errs, fmt.Errorf("headers: %w", err))
+	}
+
+	c.matchers = m
+	return errors.Join(errs...)
+}
+
+// getMatchers returns the matchers built by compile,
+// which must be called when the configuration is loaded; without them, nothing matches.
+func (c *FirebaseConfiguration) getMatchers() *firebaseMatchers {
+	if c.matchers == nil {
+		return &firebaseMatchers{}
+	}
+	return c.matchers
+}
+
+func (c FirebaseConfiguration) processRedirects(path string) (int, string) {
+	m := c.getMatchers().redirects
+	i := m.first(path, 0)
+	if i < 0 {
+		return 0, ""
+	}
+
+	redirect := c.Redirects[i]
+	dest := redirect.Destination
+	if pattern := m.patterns[i]; pattern.NumSubexp() > 0 {
+		dest = pattern.ReplaceAllString(path, CompileTemplate(dest))
+	}
+	if redirect.Type == 0 {
+		return http.StatusMovedPermanently, dest
+	}
+	return redirect.Type, dest
+}
+
+func (c FirebaseConfiguration) processRewrites(path string) string {
+	if dest, ok := c.processRewrite(path); ok {
+		return dest
 	}
 	return path
 }
 
+func (c FirebaseConfiguration) processRewrite(path string) (string, bool) {
+	if i := c.getMatchers().rewrites.first(path, 0); i >= 0 {
+		return c.Rewrites[i].Destination, true
+	}
+	return "", false
+}
+
+func (c FirebaseConfiguration) processProxy(path string) string {
+	if i := c.getMatchers().rewrites.first(path, 0); i >= 0 {
+		return c.Rewrites[i].Proxy
+	}
+	return ""
+}
+
 func (c FirebaseConfiguration) processHeaders(path string, header http.Header) {
-	for _, headers := range c.Headers {
-		pattern, err := CompileExtGlob("/" + strings.TrimPrefix(headers.Source, "/"))
-		if err != nil {
-			return
-		}
-		if pattern.MatchString(path) {
-			for _, h := range headers.Headers {
-				header.Set(h.Key, h.Value)
-			}
+	m := c.getMatchers().headers
+	for i := m.first(path, 0); i >= 0; i = m.first(path, i+1) {
+		for _, h := range c.Headers[i].Headers {
+			header.Set(h.Key, h.Value)
 		}
 	}
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_loadFirebaseFile(t *testing.T) {
+	defer func() { firebase = map[string]FirebaseConfiguration{} }()
+
+	if err := loadFirebaseFile("firebase-sample.json"); err != nil {
+		t.Fatalf("firebase-sample.json: %v", err)
+	}
+	if !firebase["example.com"].CleanUrls {
+		t.Errorf("firebase-sample.json: not loaded")
+	}
+
+	name := filepath.Join(t.TempDir(), "firebase.json")
+	os.WriteFile(name, []byte(`{
+  "example.com": {
+    "redirects": [
+      {"source": "/ok/:id", "destination": "/new/:id", "type": 302},
+      {"source": "/a[", "destination": "/b"},
+      {"source": "/c", "destination": "/d/:id", "type": 200},
+      {"regex": "/(x)", "destination": "/:1/:2"},
+      {"source": "/y", "regex": "/y"}
+    ],
+    "rewrites": [{"source": "/e"}],
+    "headers": [{"source": "**", "headers": [{"key": "Bad Key", "value": "x"}]}]
+  }
+}`), 0644)
+
+	err := loadFirebaseFile(name)
+	if err == nil {
+		t.Fatal("got nil error")
+	}
+	for _, want := range []string{
+		"example.com: redirects[1]: source \"/a[\"",
+		"example.com: redirects[2]: type 200",
+		"example.com: redirects[2]: destination \"/d/:id\": no capture \"id\" in source \"/c\"",
+		"example.com: redirects[3]: destination \"/:1/:2\": no capture \"2\" in regex \"/(x)\"",
+		"example.com: redirects[4]: source \"/y\", regex \"/y\": both source and regex",
+		"example.com: rewrites[0]: missing destination or proxy",
+		"example.com: headers[0].headers[0]: invalid key \"Bad Key\"",
+	} {
+		if !strings.Contains(err.Error(), name+": "+want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "redirects[0]") {
+		t.Errorf("unexpected redirects[0] in:\n%v", err)
+	}
+
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	os.WriteFile(name, []byte("{\n  \"example.com\": {\n    \"cleanUrl\": true,\n    \"predeploy\": [\"npm run build\"]\n  }\n}"), 0644)
+	if err := loadFirebaseFile(name); err != nil {
+		t.Errorf("got %v", err)
+	}
+	if want := "example.com: unknown key \"cleanUrl\", ignored"; !strings.Contains(logs.String(), want) || strings.Contains(logs.String(), "predeploy") {
+		t.Errorf("missing %q in:\n%s", want, logs.String())
+	}
+
+	os.WriteFile(name, []byte("{\n  \"example.com\": {\n    \"cleanUrls\": 1\n  }\n}"), 0644)
+	if err := loadFirebaseFile(name); err == nil || !strings.Contains(err.Error(), "example.com: json: cannot unmarshal number") {
+		t.Errorf("got %v", err)
+	}
+
+	os.WriteFile(name, []byte("{\n  \"example.com\": {\n    \"cleanUrls\": true,\n  }\n}"), 0644)
+	if err := loadFirebaseFile(name); err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func Test_parseFirebase(t *testing.T) {
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	config := `{
+  "firestore": {"rules": "firestore.rules"},
+  "functions": {"source": "functions"},
+  "emulators": {"hosting": {"port": 5000}},
+  "hosting": [
+    {"target": "blog", "public": "blog", "cleanUrls": true, "i18n": {"root": "/intl"}},
+    {"site": "docs", "public": "docs", "predeploy": ["make"], "rewrites": [{"source": "**", "destination": "/index.html", "redirectNotFound": true}]}
+  ]
+}`
+
+	tests := []struct {
+		site   string
+		public string
+		err    string
+	}{
+		{"example.com", "", `no entry with a site or target of "example.com"`},
+		{"previews/sites/blog", "blog", ""},
+		{"docs", "docs", ""},
+	}
+
+	for _, tt := range tests {
+		logs.Reset()
+		hosting, err := parseFirebase(context.Background(), strings.NewReader(config), "firebase.json", tt.site)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, want %q", tt.site, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || hosting.Public != tt.public {
+			t.Errorf("%s: got %q %v, want %q", tt.site, hosting.Public, err, tt.public)
+		}
+	}
+	if want := `docs: unknown key "hosting.rewrites[0].redirectNotFound", ignored`; strings.Count(logs.String(), "WARNING") != 1 || !strings.Contains(logs.String(), want) {
+		t.Errorf("missing %q in:\n%s", want, logs.String())
+	}
+
+	hosting, err := parseFirebase(context.Background(), strings.NewReader(`{"hosting": {"cleanUrls": true}}`), "firebase.json", "example.com")
+	if err != nil || !hosting.CleanUrls {
+		t.Errorf("hosting object: got %v %v", hosting, err)
+	}
+}
+
+func Test_FirebaseConfiguration_process(t *testing.T) {
+	var config FirebaseConfiguration
+	err := decodeStrict(strings.NewReader(`{
+  "redirects": [
+    {"source": "/blog/:post", "destination": "/posts/:post", "type": 302},
+    {"source": "/blog/**", "destination": "/posts"},
+    {"source": "/users/:id/:tab?", "destination": "/u/:id/:tab"},
+    {"regex": "/archive/(?P<year>[0-9]{4})/([a-z]+)", "destination": "/y/:year/:2"}
+  ],
+  "rewrites": [
+    {"source": "/app/**", "destination": "/app/index.html"},
+    {"source": "**", "destination": "/index.html"}
+  ],
+  "headers": [
+    {"source": "**/*.js", "headers": [{"key": "Cache-Control", "value": "max-age=60"}]},
+    {"source": "/static/**", "headers": [{"key": "Cache-Control", "value": "max-age=3600"}]},
+    {"source": "**", "headers": [{"key": "X-Robots-Tag", "value": "none"}]},
+    {"regex": ".*\\.(png|jpe?g)", "headers": [{"key": "Cache-Control", "value": "immutable"}]}
+  ]
+}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	redirects := []struct {
+		path     string
+		code     int
+		location string
+	}{
+		{"/blog/hello", 302, "/posts/hello"},
+		{"/blog/2019/hello", 301, "/posts"},
+		{"/users/me/", 301, "/u/me/"},
+		{"/users/me/likes", 301, "/u/me/likes"},
+		{"/archive/2019/hello", 301, "/y/2019/hello"},
+		{"/archive/2019/hello/", 0, ""},
+		{"/about", 0, ""},
+	}
+	for _, tt := range redirects {
+		if code, location := config.processRedirects(tt.path); code != tt.code || location != tt.location {
+			t.Errorf("processRedirects(%q) = %d %q, want %d %q", tt.path, code, location, tt.code, tt.location)
+		}
+	}
+
+	if got := config.processRewrites("/app/settings"); got != "/app/index.html" {
+		t.Errorf("processRewrites(/app/settings) = %q", got)
+	}
+	if got := config.processRewrites("/about"); got != "/index.html" {
+		t.Errorf("processRewrites(/about) = %q", got)
+	}
+
+	header := http.Header{}
+	config.processHeaders("/static/app.js", header)
+	if header.Get("Cache-Control") != "max-age=3600" || header.Get("X-Robots-Tag") != "none" {
+		t.Errorf("processHeaders(/static/app.js) = %v", header)
+	}
+	header = http.Header{}
+	config.processHeaders("/static/logo.jpeg", header)
+	if header.Get("Cache-Control") != "immutable" {
+		t.Errorf("processHeaders(/static/logo.jpeg) = %v", header)
+	}
+	header = http.Header{}
+	config.processHeaders("/app.js", header)
+	if header.Get("Cache-Control") != "max-age=60" || header.Get("X-Robots-Tag") != "none" {
+		t.Errorf("processHeaders(/app.js) = %v", header)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func Test_gcsClient(t *testing.T) {
+	reset := func() {
+		gcsTokens.once, gcsTokens.source = sync.Once{}, nil
+		gcsShared.once, gcsShared.client = sync.Once{}, nil
+	}
+	reset()
+	t.Cleanup(reset)
+
+	credentials := filepath.Join(t.TempDir(), "credentials.json")
+	os.WriteFile(credentials, []byte(`{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "token"}`), 0o600)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentials)
+
+	a := gcsClient(context.Background())
+	b := gcsClient(context.WithValue(context.Background(), struct{}{}, "request"))
+	if a != b {
+		t.Error("got different clients")
+	}
+
+	transport, ok := a.Transport.(*oauth2.Transport)
+	if !ok {
+		t.Fatalf("got transport %T", a.Transport)
+	}
+	if transport.Source == nil || transport.Source != gcsTokens.source {
+		t.Error("got a different token source")
+	}
+	if transport.Base != gcsTransport() {
+		t.Error("got a different base transport")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// HostConfiguration maps a host to the bucket, and optional prefix, it's served from,
+// or to the canonical host it redirects to.
+//
+// Hosts can be wildcards, as in *.example.com, which match a single label;
+// the label is substituted for {label} in the bucket name and prefix.
+// Hosts without configuration are served from the bucket with the same name.
+// Hosts with Channels also serve the preview channels of their site.
+type HostConfiguration struct {
+	Bucket   string `json:"bucket,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	Redirect string `json:"redirect,omitempty"`
+	Channels bool   `json:"channels,omitempty"`
+}
+
+var hosts = map[string]HostConfiguration{}
+
+// loadHostsFile loads and validates the local hosts.json.
+// A missing file is not an error.
+func loadHostsFile(name string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var config map[string]HostConfiguration
+	if err := decodeStrict(f, &config); err != nil {
+		return &ConfigError{File: name, Err: err}
+	}
+
+	var keys []string
+	for host := range config {
+		keys = append(keys, host)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, host := range keys {
+		if err := config[host].validate(host); err != nil {
+			errs = append(errs, &ConfigError{File: name, Site: host, Err: err})
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	hosts = map[string]HostConfiguration{}
+	for host, c := range config {
+		hosts[strings.ToLower(host)] = c
+	}
+	return nil
+}
+
+func (c HostConfiguration) validate(host string) error {
+	label := strings.TrimPrefix(host, "*.")
+	if label == "" || strings.ContainsAny(label, "*/: ") {
+		return errors.New("invalid host")
+	}
+	switch {
+	case c.Bucket != "" && c.Redirect != "":
+		return errors.New("both bucket and redirect")
+	case c.Redirect != "":
+		if c.Prefix != "" {
+			return errors.New("both prefix and redirect")
+		}
+		if strings.ContainsAny(c.Redirect, "/ ") {
+			return fmt.Errorf("redirect %q: invalid host", c.Redirect)
+		}
+	case c.Bucket != "":
+		if strings.Contains(c.Bucket, "{label}") && !strings.HasPrefix(host, "*.") {
+			return fmt.Errorf("bucket %q: {label} only allowed for wildcard hosts", c.Bucket)
+		}
+		if !validBucket(strings.ReplaceAll(c.Bucket, "{label}", "label")) {
+			return fmt.Errorf("bucket %q: invalid bucket", c.Bucket)
+		}
+		if strings.Contains(c.Prefix, "{label}") && !strings.HasPrefix(host, "*.") {
+			return fmt.Errorf("prefix %q: {label} only allowed for wildcard hosts", c.Prefix)
+		}
+		for _, segment := range strings.Split(strings.Trim(c.Prefix, "/"), "/") {
+			if c.Prefix != "" && (segment == "" || segment == "." || segment == "..") {
+				return fmt.Errorf("prefix %q: invalid prefix", c.Prefix)
+			}
+		}
+	default:
+		return errors.New("missing bucket or redirect")
+	}
+	return nil
+}
+
+// resolveHost returns the bucket and prefix a host is served from,
+// or the canonical host it should redirect to.
+// A prefix starts, but doesn't end, with a slash.
+func resolveHost(host string) (bucket, prefix, redirect string) {
+	host = normalizeHost(host)
+
+	c, label, ok := lookupHost(host)
+	if !ok {
+		return host, "", ""
+	}
+	if c.Redirect != "" {
+		return "", "", c.Redirect
+	}
+	if c.Prefix != "" {
+		prefix = "/" + strings.Trim(strings.ReplaceAll(c.Prefix, "{label}", label), "/")
+	}
+	return strings.ReplaceAll(c.Bucket, "{label}", label), prefix, ""
+}
+
+// lookupHost returns the configuration of a normalized host,
+// and the label matched by a wildcard.
+func lookupHost(host string) (c HostConfiguration, label string, ok bool) {
+	if c, ok = hosts[host]; ok {
+		return c, "", true
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		c, ok = hosts["*"+host[i:]]
+		return c, host[:i], ok
+	}
+	return c, "", false
+}
+
+// normalizeHost strips the port and trailing dot, and lowercases host.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
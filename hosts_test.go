@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_resolveHost(t *testing.T) {
+	defer func() { hosts = map[string]HostConfiguration{} }()
+
+	if err := loadHostsFile("hosts-sample.json"); err != nil {
+		t.Fatalf("hosts-sample.json: %v", err)
+	}
+
+	tests := []struct {
+		host, bucket, prefix, redirect string
+	}{
+		{"example.com", "example.com", "", ""},
+		{"Example.COM:8080", "example.com", "", ""},
+		{"example.net", "example.com", "", ""},
+		{"www.example.com", "", "", "example.com"},
+		{"pr-42.example.dev", "pr-42.example.dev", "", ""},
+		{"a.b.example.dev", "a.b.example.dev", "", ""},
+		{"feature.preview.example.com", "previews.example.com", "/sites/feature", ""},
+		{"example.org", "example.org", "", ""},
+		{"[::1]:8080", "::1", "", ""},
+	}
+
+	for _, tt := range tests {
+		if bucket, prefix, redirect := resolveHost(tt.host); bucket != tt.bucket || prefix != tt.prefix || redirect != tt.redirect {
+			t.Errorf("resolveHost(%q) = %q %q %q, want %q %q %q", tt.host, bucket, prefix, redirect, tt.bucket, tt.prefix, tt.redirect)
+		}
+	}
+
+	setStorage(t, memStorage{"example.com/index.html": "home"})
+
+	w, res := serve("GET", "http://example.net/")
+	if res.Status != http.StatusOK || w.Body.String() != "home" {
+		t.Errorf("GET example.net: got %d %q", res.Status, w.Body.String())
+	}
+	_, res = serve("GET", "http://www.example.com/a?b=c")
+	if res.Status != http.StatusMovedPermanently || res.Location != "http://example.com/a?b=c" {
+		t.Errorf("GET www.example.com: got %d %q", res.Status, res.Location)
+	}
+}
+
+func Test_loadHostsFile(t *testing.T) {
+	defer func() { hosts = map[string]HostConfiguration{} }()
+
+	name := filepath.Join(t.TempDir(), "hosts.json")
+	os.WriteFile(name, []byte(`{
+  "a.com": {},
+  "b.com": {"bucket": "b", "redirect": "c.com"},
+  "c.com": {"bucket": "{label}"},
+  "*.d.com": {"bucket": "../{label}"},
+  "*.e.com": {"bucket": "e", "prefix": "/sites/../{label}"}
+}`), 0644)
+
+	err := loadHostsFile(name)
+	if err == nil {
+		t.Fatal("got nil error")
+	}
+	for _, want := range []string{
+		"a.com: missing bucket or redirect",
+		"b.com: both bucket and redirect",
+		"c.com: bucket \"{label}\": {label} only allowed for wildcard hosts",
+		"*.d.com: bucket \"../{label}\": invalid bucket",
+		"*.e.com: prefix \"/sites/../{label}\": invalid prefix",
+	} {
+		if !strings.Contains(err.Error(), name+": "+want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+}
+
+func Test_prefixSites(t *testing.T) {
+	hosts = map[string]HostConfiguration{"*.preview.example.com": {Bucket: "previews", Prefix: "sites/{label}"}}
+	defer func() { hosts = map[string]HostConfiguration{} }()
+
+	setStorage(t, memStorage{
+		"previews/index.html":                     "root",
+		"previews/sites/a/index.html":             "a home",
+		"previews/sites/a/404.html":               "a missing",
+		"previews/sites/a/docs/index.html":        "a docs",
+		"previews/sites/a/about.html":             "a about",
+		"previews/sites/a/app/index.html":         "a app",
+		"previews/sites/a/.hosting/firebase.json": `{"cleanUrls": true, "rewrites": [{"source": "/app/**", "destination": "/app/index.html"}]}`,
+		"previews/sites/b/index.html":             "b home",
+		"previews/sites/b/.hosting/firebase.json": `{"rewrites": [{"source": "**", "destination": "gs://previews/index.html"}]}`,
+	})
+
+	tests := []struct {
+		target   string
+		status   int
+		location string
+		body     string
+	}{
+		{"http://a.preview.example.com/", http.StatusOK, "", "a home"},
+		{"http://a.preview.example.com/docs/", http.StatusOK, "", "a docs"},
+		{"http://a.preview.example.com/about", http.StatusOK, "", "a about"},
+		{"http://a.preview.example.com/about.html", http.StatusMovedPermanently, "/about", ""},
+		{"http://a.preview.example.com/app/x/y", http.StatusOK, "", "a app"},
+		{"http://a.preview.example.com/missing", http.StatusNotFound, "", "a missing"},
+		{"http://a.preview.example.com/.hosting/firebase.json", http.StatusNotFound, "", "a missing"},
+		{"http://a.preview.example.com/../b/index.html", http.StatusNotFound, "", "a missing"},
+		{"http://a.preview.example.com/%2e%2e/b/index.html", http.StatusNotFound, "", "a missing"},
+		{"http://a.preview.example.com/docs/..%2F..%2Fb/index.html", http.StatusNotFound, "", "a missing"},
+		{"http://a.preview.example.com/../../index.html", http.StatusNotFound, "", "a missing"},
+		{"http://a.preview.example.com/docs/../about.html", http.StatusNotFound, "", "a missing"},
+		{"http://b.preview.example.com/", http.StatusInternalServerError, "", ""},
+	}
+
+	for _, tt := range tests {
+		w, res := serve("GET", tt.target)
+		body := w.Body.String()
+		if res.Status != tt.status || res.Location != tt.location || body != tt.body {
+			t.Errorf("GET %s: got %d %q %q, want %d %q %q", tt.target, res.Status, res.Location, body, tt.status, tt.location, tt.body)
+		}
+	}
+}
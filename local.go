@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LocalStorage serves objects from a local directory tree,
+// with one subdirectory per bucket.
+//
+// Metadata is synthesized from the file system:
+// the Etag from size and modification time, the Content-Type from the extension.
+// Open honors a single byte range.
+type LocalStorage struct {
+	Root   string
+	Config WebsiteConfiguration
+}
+
+func (s LocalStorage) Stat(ctx context.Context, bucket, object string) (*http.Response, error) {
+	res, err := s.Open(ctx, bucket, object, nil)
+	if err == nil {
+		res.Body.Close()
+		res.Body = http.NoBody
+	}
+	return res, err
+}
+
+func (s LocalStorage) Open(ctx context.Context, bucket, object string, header http.Header) (*http.Response, error) {
+	name, err := url.PathUnescape(object)
+	if err != nil || !validBucket(bucket) {
+		return notFoundResponse(), nil
+	}
+
+	f, err := http.Dir(filepath.Join(s.Root, bucket)).Open(name)
+	if os.IsNotExist(err) {
+		return notFoundResponse(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		return notFoundResponse(), nil
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	size := strconv.FormatInt(fi.Size(), 10)
+	res := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		Body:          f,
+		ContentLength: fi.Size(),
+	}
+	res.Header.Set("Etag", `"`+strconv.FormatInt(fi.ModTime().UnixNano(), 16)+"-"+strconv.FormatInt(fi.Size(), 16)+`"`)
+	res.Header.Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	res.Header.Set("Content-Type", contentType)
+	res.Header.Set("Content-Length", size)
+	res.Header.Set("x-goog-stored-content-length", size)
+
+	if ranges, err := parseRange(header.Get("Range"), fi.Size()); err == nil && len(ranges) == 1 {
+		ra := ranges[0]
+		if _, err := f.Seek(ra.start, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		res.Status = "206 Partial Content"
+		res.StatusCode = http.StatusPartialContent
+		res.ContentLength = ra.length
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(f, ra.length), f}
+		res.Header.Set("Content-Range", ra.contentRange(fi.Size()))
+		res.Header.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+	}
+	return res, nil
+}
+
+func (s LocalStorage) Website(ctx context.Context, bucket string) (WebsiteConfiguration, error) {
+	if !validBucket(bucket) {
+		return WebsiteConfiguration{}, errors.New(http.StatusText(http.StatusNotFound))
+	}
+	fi, err := os.Stat(filepath.Join(s.Root, bucket))
+	if err != nil {
+		return WebsiteConfiguration{}, err
+	}
+	if !fi.IsDir() {
+		return WebsiteConfiguration{}, errors.New(http.StatusText(http.StatusNotFound))
+	}
+	return s.Config, nil
+}
+
+func validBucket(bucket string) bool {
+	return bucket != "" && bucket != "." && bucket != ".." && !strings.ContainsAny(bucket, `/\`)
+}
+
+func notFoundResponse() *http.Response {
+	return &http.Response{
+		Status:     "404 Not Found",
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+	}
+}
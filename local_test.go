@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LocalStorage(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "example.com", "blog"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "example.com", "index.html"), []byte("home"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "example.com", "blog", "a b.css"), []byte("body{}"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0644)
+
+	s := LocalStorage{Root: root}
+	ctx := context.Background()
+
+	res, err := s.Open(ctx, "example.com", "/blog/a%20b.css", nil)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Open: %v %v", res, err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "body{}" {
+		t.Errorf("Open: got body %q", body)
+	}
+	if got := res.Header.Get("Content-Type"); got != "text/css; charset=utf-8" {
+		t.Errorf("Content-Type: got %q", got)
+	}
+	if got := res.Header.Get("x-goog-stored-content-length"); got != "6" {
+		t.Errorf("x-goog-stored-content-length: got %q", got)
+	}
+	if etag := res.Header.Get("Etag"); etag == "" || etag[0] != '"' {
+		t.Errorf("Etag: got %q", etag)
+	}
+	if _, err := http.ParseTime(res.Header.Get("Last-Modified")); err != nil {
+		t.Errorf("Last-Modified: %v", err)
+	}
+
+	for _, object := range []string{"/blog", "/blog/", "/missing", "/../secret", "/%2e%2e/secret"} {
+		res, err := s.Stat(ctx, "example.com", object)
+		if err != nil || res.StatusCode != http.StatusNotFound {
+			t.Errorf("Stat %s: got %v %v", object, res, err)
+		}
+	}
+
+	if _, err := s.Website(ctx, ".."); err == nil {
+		t.Errorf("Website ..: got nil error")
+	}
+	if _, err := s.Website(ctx, "example.org"); err == nil {
+		t.Errorf("Website example.org: got nil error")
+	}
+}
+
+func Test_LocalStorage_notFoundPage(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "example.com"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "example.com", "index.html"), []byte("home"), 0644)
+	setStorage(t, newLocalStorage(root))
+
+	if w, res := serve("GET", "http://example.com/"); res.Status != http.StatusOK || w.Body.String() != "home" {
+		t.Errorf("GET /: got %d %q", res.Status, w.Body.String())
+	}
+	if w, res := serve("GET", "http://example.com/missing"); res.Status != http.StatusNotFound || w.Body.Len() != 0 {
+		t.Errorf("GET /missing: got %d %q", res.Status, w.Body.String())
+	}
+
+	ioutil.WriteFile(filepath.Join(root, "example.com", "404.html"), []byte("missing"), 0644)
+	if w, res := serve("GET", "http://example.com/missing"); res.Status != http.StatusNotFound || w.Body.String() != "missing" {
+		t.Errorf("GET /missing: got %d %q", res.Status, w.Body.String())
+	}
+}
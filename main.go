@@ -1,17 +1,15 @@
 package main
 
 import (
-	"google.golang.org/appengine"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 )
 
-func main() {
-	http.HandleFunc("/", Main)
-	appengine.Main()
-}
-
 func Main(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+	ctx := newContext(r)
 
 	switch res := StaticWebsiteHandler(w, r.WithContext(ctx)); {
 
@@ -42,3 +40,50 @@ type HttpResult struct {
 	Message  string
 	Location string
 }
+
+func configure() {
+	if err := loadFirebaseFile("firebase.json"); err != nil {
+		log.Fatalf("Invalid hosting configuration:\n%v", err)
+	}
+	if err := loadHostsFile("hosts.json"); err != nil {
+		log.Fatalf("Invalid hosts configuration:\n%v", err)
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("WEBSITE_CACHE_TTL")); err == nil {
+		websites.TTL = ttl
+		firebases.TTL = ttl
+	}
+	if n, err := strconv.Atoi(os.Getenv("STORAGE_MAX_IDLE_CONNS")); err == nil {
+		storageTransport.MaxIdleConns = n
+		storageTransport.MaxIdleConnsPerHost = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("STORAGE_MAX_CONNS")); err == nil {
+		storageTransport.MaxConnsPerHost = n
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("STORAGE_IDLE_TIMEOUT")); err == nil {
+		storageTransport.IdleConnTimeout = timeout
+	}
+	if os.Getenv("COMPRESS_TEXT") != "" {
+		compressText = true
+	}
+	if root := os.Getenv("LOCAL_STORAGE_ROOT"); root != "" {
+		storage = newLocalStorage(root)
+	}
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		storage = S3Storage{
+			Endpoint:     endpoint,
+			Region:       os.Getenv("AWS_REGION"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+			PathStyle:    os.Getenv("S3_PATH_STYLE") != "",
+			Client:       &http.Client{Transport: storageTransport},
+		}
+	}
+}
+
+func newLocalStorage(root string) LocalStorage {
+	return LocalStorage{
+		Root:   root,
+		Config: WebsiteConfiguration{MainPageSuffix: "index.html", NotFoundPage: "404.html"},
+	}
+}
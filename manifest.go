@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Manifest describes a release by content: it maps URL paths to files,
+// whose content is stored once, by hash, in the site's hostingPrefix + "blobs/".
+//
+// It's read from the site's hostingPrefix + "releases/" + version + ".json" object,
+// for releases published with a manifest.
+// Blobs stored compressed must declare their Encoding, and be uploaded with it.
+// Files may have a CacheControl, as blobs are shared by files.
+type Manifest struct {
+	Files map[string]ManifestFile `json:"files"`
+
+	modified string
+}
+
+type ManifestFile struct {
+	Hash         string `json:"hash"`
+	Size         int64  `json:"size"`
+	Type         string `json:"type,omitempty"`
+	Encoding     string `json:"encoding,omitempty"`
+	CacheControl string `json:"cacheControl,omitempty"`
+}
+
+var manifests = &Cache[*Manifest]{TTL: 5 * time.Minute, NegativeTTL: 10 * time.Second}
+
+// manifestStorage serves objects under prefix from a manifest,
+// answering Stat from memory, and reading content from blobs.
+// Other objects are served by the underlying Storage.
+type manifestStorage struct {
+	Storage
+	bucket   string
+	prefix   string
+	blobs    string
+	manifest *Manifest
+}
+
+func (s manifestStorage) lookup(bucket, object string) (ManifestFile, bool, bool) {
+	name, ok := strings.CutPrefix(object, s.prefix)
+	if bucket != s.bucket || !ok || !strings.HasPrefix(name, "/") {
+		return ManifestFile{}, false, false
+	}
+	name, err := url.PathUnescape(name)
+	if err != nil {
+		return ManifestFile{}, false, true
+	}
+	file, found := s.manifest.Files[name]
+	return file, found, true
+}
+
+// immutable reports whether the object is served from a manifest,
+// so its Etag is strong, and its content never changes.
+func (ctx *HandlerContext) immutable() bool {
+	s, ok := ctx.storage.(manifestStorage)
+	if !ok {
+		return false
+	}
+	_, found, _ := s.lookup(ctx.objectBucket, ctx.objectName())
+	return found
+}
+
+func (s manifestStorage) header(name string, file ManifestFile) http.Header {
+	contentType := file.Type
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	encoding := file.Encoding
+	if encoding == "" {
+		encoding = "identity"
+	}
+	header := http.Header{
+		"Etag":                           {`"` + file.Hash + `"`},
+		"Last-Modified":                  {s.manifest.modified},
+		"Content-Type":                   {contentType},
+		"X-Goog-Stored-Content-Length":   {strconv.FormatInt(file.Size, 10)},
+		"X-Goog-Stored-Content-Encoding": {encoding},
+	}
+	if file.CacheControl != "" {
+		header.Set("Cache-Control", file.CacheControl)
+	}
+	return header
+}
+
+func (s manifestStorage) Stat(ctx context.Context, bucket, object string) (*http.Response, error) {
+	file, found, ok := s.lookup(bucket, object)
+	if !ok {
+		return s.Storage.Stat(ctx, bucket, object)
+	}
+	if !found {
+		return notFoundResponse(), nil
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     s.header(object, file),
+		Body:       http.NoBody,
+	}, nil
+}
+
+func (s manifestStorage) Open(ctx context.Context, bucket, object string, header http.Header) (*http.Response, error) {
+	file, found, ok := s.lookup(bucket, object)
+	if !ok {
+		return s.Storage.Open(ctx, bucket, object, header)
+	}
+	if !found {
+		return notFoundResponse(), nil
+	}
+	res, err := s.Storage.Open(ctx, bucket, s.blobs+file.Hash, header)
+	if err == nil && (res.StatusCode == http.StatusOK || res.StatusCode == http.StatusPartialContent) {
+		for k, v := range s.header(object, file) {
+			res.Header[k] = v
+		}
+	}
+	return res, err
+}
+
+func loadManifest(ctx context.Context, storage Storage, bucket, prefix, version string) (*Manifest, error) {
+	name := hostingPrefix + "releases/" + version + ".json"
+
+	res, err := storage.Open(ctx, bucket, prefix+name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(http.StatusText(res.StatusCode))
+	}
+
+	var manifest Manifest
+	if err := decodeStrict(res.Body, &manifest); err != nil {
+		return nil, &ConfigError{File: name, Site: bucket + prefix, Err: err}
+	}
+	if err := manifest.validate(name, bucket+prefix); err != nil {
+		return nil, err
+	}
+
+	manifest.modified = res.Header.Get("Last-Modified")
+	if manifest.modified == "" {
+		manifest.modified = time.Now().UTC().Format(http.TimeFormat)
+	}
+	return &manifest, nil
+}
+
+func (m *Manifest) validate(name, site string) error {
+	var keys []string
+	for path := range m.Files {
+		keys = append(keys, path)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, path := range keys {
+		file := m.Files[path]
+		switch {
+		case !strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/"):
+			errs = append(errs, &ConfigError{File: name, Site: site, Rule: path, Err: errors.New("invalid path")})
+		case file.Hash == "" || strings.Trim(file.Hash, "0123456789abcdef") != "":
+			errs = append(errs, &ConfigError{File: name, Site: site, Rule: path, Err: fmt.Errorf("hash %q: must be lowercase hex", file.Hash)})
+		case file.Size < 0:
+			errs = append(errs, &ConfigError{File: name, Site: site, Rule: path, Err: fmt.Errorf("size %d: invalid size", file.Size)})
+		}
+	}
+	return errors.Join(errs...)
+}
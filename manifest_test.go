@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type countingStorage struct {
+	Storage
+	mu     sync.Mutex
+	stats  int
+	opens  int
+	header http.Header
+}
+
+func (s *countingStorage) Stat(ctx context.Context, bucket, object string) (*http.Response, error) {
+	s.mu.Lock()
+	s.stats++
+	s.mu.Unlock()
+	return s.Storage.Stat(ctx, bucket, object)
+}
+
+func (s *countingStorage) Open(ctx context.Context, bucket, object string, header http.Header) (*http.Response, error) {
+	s.mu.Lock()
+	s.opens++
+	s.header = header
+	s.mu.Unlock()
+	return s.Storage.Open(ctx, bucket, object, header)
+}
+
+func Test_manifest(t *testing.T) {
+	counter := &countingStorage{Storage: memStorage{
+		"example.com/.hosting/release.json": `{"version": "v1", "manifest": true}`,
+		"example.com/.hosting/releases/v1.json": `{"files": {
+			"/index.html": {"hash": "aaaa", "size": 4},
+			"/404.html": {"hash": "bbbb", "size": 7},
+			"/about.html": {"hash": "cccc", "size": 5},
+			"/docs/index.html": {"hash": "aaaa", "size": 4},
+			"/data.json": {"hash": "dddd", "size": 2, "cacheControl": "max-age=60"},
+			"/café.html": {"hash": "eeee", "size": 4},
+			"/.hosting/firebase.json": {"hash": "ffff", "size": 16}
+		}}`,
+		"example.com/.hosting/blobs/aaaa":       "home",
+		"example.com/.hosting/blobs/bbbb":       "missing",
+		"example.com/.hosting/blobs/cccc":       "about",
+		"example.com/.hosting/blobs/dddd":       "{}",
+		"example.com/.hosting/blobs/eeee":       "café",
+		"example.com/.hosting/blobs/ffff":       `{"cleanUrls": true}`,
+		"example.org/.hosting/release.json":     `{"version": "v1", "manifest": true}`,
+		"example.org/.hosting/releases/v1.json": `{"files": {"/index.html": {"hash": "AAAA"}}}`,
+	}}
+	setStorage(t, counter)
+
+	tests := []struct {
+		target string
+		status int
+		etag   string
+		ctype  string
+		body   string
+	}{
+		{"http://example.com/", http.StatusOK, `"aaaa"`, "text/html; charset=utf-8", "home"},
+		{"http://example.com/about", http.StatusOK, `"cccc"`, "text/html; charset=utf-8", "about"},
+		{"http://example.com/docs/", http.StatusOK, `"aaaa"`, "text/html; charset=utf-8", "home"},
+		{"http://example.com/data.json", http.StatusOK, `"dddd"`, "application/json", "{}"},
+		{"http://example.com/caf%C3%A9", http.StatusOK, `"eeee"`, "text/html; charset=utf-8", "café"},
+		{"http://example.com/missing", http.StatusNotFound, "", "text/html; charset=utf-8", "missing"},
+		{"http://example.com/.hosting/blobs/aaaa", http.StatusNotFound, "", "text/html; charset=utf-8", "missing"},
+		{"http://example.org/", http.StatusInternalServerError, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		w, res := serve("GET", tt.target)
+		etag := w.Header().Get("Etag")
+		ctype := w.Header().Get("Content-Type")
+		body := w.Body.String()
+		if res.Status != tt.status || etag != tt.etag || ctype != tt.ctype || body != tt.body {
+			t.Errorf("GET %s: got %d %q %q %q, want %d %q %q %q", tt.target, res.Status, etag, ctype, body, tt.status, tt.etag, tt.ctype, tt.body)
+		}
+	}
+
+	if w, _ := serve("GET", "http://example.com/data.json"); w.Header().Get("Cache-Control") != "max-age=60" {
+		t.Errorf("GET /data.json: got Cache-Control %q", w.Header().Get("Cache-Control"))
+	}
+
+	r := httptest.NewRequest("GET", "http://example.com/about", nil)
+	r.Header.Set("If-None-Match", `"cccc"`)
+	w := httptest.NewRecorder()
+	if res := StaticWebsiteHandler(w, r); res.Status != http.StatusNotModified || w.Header().Get("Etag") != `"cccc"` {
+		t.Errorf("GET /about If-None-Match: got %d %q, want 304", res.Status, w.Header().Get("Etag"))
+	}
+
+	if counter.stats != 0 {
+		t.Errorf("got %d HEAD requests, want 0", counter.stats)
+	}
+}
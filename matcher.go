@@ -0,0 +1,73 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// matcherSet matches paths against an ordered list of anchored patterns.
+//
+// Patterns are combined into a single regexp, with one capture group per pattern,
+// so finding the first matching pattern takes a single pass over the path,
+// regardless of the number of patterns.
+type matcherSet struct {
+	patterns []*regexp.Regexp
+	groups   []int
+	combined *regexp.Regexp
+}
+
+func newMatcherSet(patterns []*regexp.Regexp) (*matcherSet, error) {
+	m := &matcherSet{
+		patterns: patterns,
+		groups:   make([]int, len(patterns)),
+	}
+
+	var b strings.Builder
+	b.WriteString("^(?:")
+	for i, p := range patterns {
+		if i > 0 {
+			m.groups[i] = m.groups[i-1] + 1 + patterns[i-1].NumSubexp()
+			b.WriteByte('|')
+		}
+		b.WriteByte('(')
+		b.WriteString(strings.TrimSuffix(strings.TrimPrefix(p.String(), "^"), "$"))
+		b.WriteByte(')')
+	}
+	b.WriteString(")$")
+
+	var err error
+	if len(patterns) > 0 {
+		m.combined, err = regexp.Compile(b.String())
+	}
+	return m, err
+}
+
+// first returns the index of the first pattern, from the i-th on, that matches path,
+// or -1 if none match.
+//
+// The combined regexp finds the first match; later ones are matched one by one.
+func (m *matcherSet) first(path string, i int) int {
+	if m == nil || m.combined == nil || i >= len(m.patterns) {
+		return -1
+	}
+
+	if i > 0 {
+		for j := i; j < len(m.patterns); j++ {
+			if m.patterns[j].MatchString(path) {
+				return j
+			}
+		}
+		return -1
+	}
+
+	match := m.combined.FindStringSubmatchIndex(path)
+	if match == nil {
+		return -1
+	}
+	for j := range m.patterns {
+		if group := 2 * (m.groups[j] + 1); match[group] >= 0 {
+			return j
+		}
+	}
+	return -1
+}
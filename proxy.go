@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// proxyStripHeaders are request headers never forwarded to backends,
+// as they'd let clients query cloud metadata servers.
+// Hop-by-hop headers are removed by httputil.ReverseProxy.
+var proxyStripHeaders = []string{
+	"Metadata",
+	"Metadata-Flavor",
+	"X-Aws-Ec2-Metadata-Token",
+	"X-Aws-Ec2-Metadata-Token-Ttl-Seconds",
+	"X-Google-Metadata-Request",
+}
+
+func (ctx *HandlerContext) getProxy() string {
+	return ctx.firebase.processProxy(ctx.r.URL.Path)
+}
+
+// sendProxy forwards the request to a backend,
+// appending the request path to the backend URL, and streams the response back.
+func (ctx *HandlerContext) sendProxy(backend string) HttpResult {
+	target, err := url.Parse(backend)
+	if err != nil {
+		logErrorf(ctx.r.Context(), "Proxy %s: %v", backend, err)
+		return HttpResult{Status: http.StatusInternalServerError}
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			for _, k := range proxyStripHeaders {
+				r.Out.Header.Del(k)
+			}
+		},
+		ModifyResponse: func(res *http.Response) error {
+			setHeaders(res.Header)
+			ctx.firebase.processHeaders(ctx.r.URL.Path, res.Header)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logErrorf(r.Context(), "Proxy %s: %v", backend, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+		FlushInterval: -1,
+	}
+
+	proxy.ServeHTTP(ctx.w, ctx.r)
+	return HttpResult{}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_sendProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Forwarded-Host", r.Header.Get("X-Forwarded-Host"))
+		for _, k := range []string{"Metadata-Flavor", "X-Secret", "X-Client"} {
+			if v := r.Header.Get(k); v != "" {
+				w.Header().Set("Got-"+k, v)
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body)))
+	}))
+	defer backend.Close()
+
+	setStorage(t, memStorage{
+		"example.com/index.html":             "home",
+		"example.com/api/doc.html":           "doc",
+		"example.org/.hosting/firebase.json": `{"rewrites": [{"source": "**", "proxy": "http://metadata.google.internal/"}]}`,
+	})
+
+	var config FirebaseConfiguration
+	decodeStrict(strings.NewReader(`{
+  "rewrites": [{"source": "/api/**", "proxy": "`+backend.URL+`/v1"}],
+  "headers": [{"source": "/api/**", "headers": [{"key": "X-Api", "value": "yes"}]}]
+}`), &config)
+	config.compile()
+	firebase["example.com"] = config
+
+	tests := []struct {
+		method, target, body string
+		status               int
+		response             string
+	}{
+		{"POST", "http://example.com/api/items?x=1", "data", http.StatusCreated, "POST /v1/api/items?x=1 data"},
+		{"GET", "http://example.com/api/items", "", http.StatusCreated, "GET /v1/api/items "},
+		{"GET", "http://example.com/api/doc.html", "", http.StatusOK, "doc"},
+		{"POST", "http://example.com/index.html", "", http.StatusMethodNotAllowed, ""},
+		{"POST", "http://example.org/computeMetadata/v1/", "", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		r.Header.Set("Metadata-Flavor", "Google")
+		r.Header.Set("Connection", "X-Secret")
+		r.Header.Set("X-Secret", "hop")
+		r.Header.Set("X-Client", "ok")
+		res := StaticWebsiteHandler(w, r)
+		if res.Status == 0 {
+			res.Status = w.Code
+		}
+
+		if res.Status != tt.status || w.Body.String() != tt.response {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.method, tt.target, res.Status, w.Body.String(), tt.status, tt.response)
+		}
+		if tt.status == http.StatusCreated {
+			if w.Header().Get("X-Api") != "yes" || w.Header().Get("X-Forwarded-Host") != "example.com" ||
+				w.Header().Get("Got-X-Client") != "ok" || w.Header().Get("Got-Metadata-Flavor") != "" || w.Header().Get("Got-X-Secret") != "" {
+				t.Errorf("%s %s: got headers %v", tt.method, tt.target, w.Header())
+			}
+		}
+	}
+}
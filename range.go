@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errInvalidRange = errors.New("invalid range")
+var errNoOverlap = errors.New("invalid range: failed to overlap")
+
+// maxRanges limits the ranges of a request, as each is a separate request to storage.
+const maxRanges = 100
+
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+func (r httpRange) header() http.Header {
+	return http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", r.start, r.start+r.length-1)}}
+}
+
+// parseRange parses a Range header string as per RFC 7233.
+// errNoOverlap is returned if none of the ranges overlap the object.
+func parseRange(s string, size int64) ([]httpRange, error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errInvalidRange
+	}
+
+	var ranges []httpRange
+	var noOverlap bool
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		start, end, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		start, end = textproto.TrimString(start), textproto.TrimString(end)
+
+		var r httpRange
+		if start == "" {
+			// suffix-byte-range-spec: the final N bytes
+			i, err := strconv.ParseInt(end, 10, 64)
+			if end == "" || end[0] == '-' || err != nil {
+				return nil, errInvalidRange
+			}
+			if i > size {
+				i = size
+			}
+			if i == 0 {
+				noOverlap = true
+				continue
+			}
+			r.start = size - i
+			r.length = i
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errInvalidRange
+			}
+			if i >= size {
+				noOverlap = true
+				continue
+			}
+			r.start = i
+			if end == "" {
+				r.length = size - r.start
+			} else {
+				i, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || r.start > i {
+					return nil, errInvalidRange
+				}
+				if i >= size {
+					i = size - 1
+				}
+				r.length = i - r.start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+func sumRanges(ranges []httpRange) (size int64) {
+	for _, r := range ranges {
+		size += r.length
+	}
+	return size
+}
+
+// mergeRanges sorts ranges, and merges those that overlap or are adjacent.
+func mergeRanges(ranges []httpRange) []httpRange {
+	sorted := append([]httpRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+
+	var merged []httpRange
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && r.start <= merged[n-1].start+merged[n-1].length {
+			last := &merged[n-1]
+			if end := r.start + r.length; end > last.start+last.length {
+				last.length = end - last.start
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// checkIfRange reports whether a Range request should be honored.
+//
+// Entity tags are compared strongly against the stored Etag.
+// Since Last-Modified is sent as the time of the response,
+// a date matches if the object wasn't modified after it.
+func checkIfRange(r *http.Request, etag string, lastModified string) bool {
+	condition := r.Header.Get("If-Range")
+	if condition == "" {
+		return true
+	}
+	if condition[0] == '"' {
+		return condition == etag
+	}
+	since, err := http.ParseTime(condition)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && !modified.After(since)
+}
+
+// openRange opens a byte range of the current object,
+// skipping to it if the storage ignored the Range header.
+func (ctx *HandlerContext) openRange(r httpRange) (io.ReadCloser, error) {
+	res, err := ctx.storage.Open(ctx.r.Context(), ctx.objectBucket, ctx.objectName(), r.header())
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if _, err := io.CopyN(ioutil.Discard, res.Body, r.start); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	default:
+		res.Body.Close()
+		return nil, errors.New(http.StatusText(res.StatusCode))
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(res.Body, r.length), res.Body}, nil
+}
+
+func (ctx *HandlerContext) sendRanges(ranges []httpRange, size int64) HttpResult {
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		ctx.w.Header().Set("Content-Range", ra.contentRange(size))
+		ctx.w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		if ctx.r.Method == "HEAD" {
+			ctx.w.WriteHeader(http.StatusPartialContent)
+			return HttpResult{}
+		}
+
+		body, err := ctx.openRange(ra)
+		if err != nil {
+			logErrorf(ctx.r.Context(), "GET %s: %v", ctx.objectBucket+ctx.objectName(), err)
+			return HttpResult{Status: http.StatusInternalServerError}
+		}
+		defer body.Close()
+
+		ctx.w.WriteHeader(http.StatusPartialContent)
+		io.Copy(ctx.w, body)
+		return HttpResult{}
+	}
+
+	contentType := ctx.w.Header().Get("Content-Type")
+	mw := multipart.NewWriter(ctx.w)
+	ctx.w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	ctx.w.WriteHeader(http.StatusPartialContent)
+	if ctx.r.Method == "HEAD" {
+		return HttpResult{}
+	}
+
+	for _, ra := range ranges {
+		body, err := ctx.openRange(ra)
+		if err != nil {
+			logErrorf(ctx.r.Context(), "GET %s: %v", ctx.objectBucket+ctx.objectName(), err)
+			return HttpResult{}
+		}
+
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Range": {ra.contentRange(size)},
+			"Content-Type":  {contentType},
+		})
+		if err == nil {
+			_, err = io.Copy(part, body)
+		}
+		body.Close()
+		if err != nil {
+			return HttpResult{}
+		}
+	}
+
+	mw.Close()
+	return HttpResult{}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_parseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		ranges []httpRange
+		err    error
+	}{
+		{"bytes=0-4", []httpRange{{0, 5}}, nil},
+		{"bytes=2-", []httpRange{{2, 8}}, nil},
+		{"bytes=-3", []httpRange{{7, 3}}, nil},
+		{"bytes=-20", []httpRange{{0, 10}}, nil},
+		{"bytes=5-100", []httpRange{{5, 5}}, nil},
+		{"bytes=0-0, 9-9", []httpRange{{0, 1}, {9, 1}}, nil},
+		{"bytes=0-0, 20-30", []httpRange{{0, 1}}, nil},
+		{"bytes=10-", nil, errNoOverlap},
+		{"bytes=-0", nil, errNoOverlap},
+		{"bytes=5-4", nil, errInvalidRange},
+		{"bytes=x-4", nil, errInvalidRange},
+		{"bytes=4", nil, errInvalidRange},
+		{"items=0-4", nil, errInvalidRange},
+	}
+
+	for _, tt := range tests {
+		ranges, err := parseRange(tt.header, 10)
+		if err != tt.err || len(ranges) != len(tt.ranges) {
+			t.Errorf("%s: got %v %v, want %v %v", tt.header, ranges, err, tt.ranges, tt.err)
+			continue
+		}
+		for i := range ranges {
+			if ranges[i] != tt.ranges[i] {
+				t.Errorf("%s: got %v, want %v", tt.header, ranges, tt.ranges)
+			}
+		}
+	}
+}
+
+func Test_mergeRanges(t *testing.T) {
+	tests := []struct {
+		ranges, merged []httpRange
+	}{
+		{[]httpRange{{0, 1}, {9, 1}}, []httpRange{{0, 1}, {9, 1}}},
+		{[]httpRange{{9, 1}, {0, 1}}, []httpRange{{0, 1}, {9, 1}}},
+		{[]httpRange{{0, 2}, {2, 2}}, []httpRange{{0, 4}}},
+		{[]httpRange{{0, 5}, {1, 1}, {3, 4}}, []httpRange{{0, 7}}},
+		{[]httpRange{{5, 1}, {0, 2}, {1, 1}}, []httpRange{{0, 2}, {5, 1}}},
+	}
+
+	for _, tt := range tests {
+		merged := mergeRanges(tt.ranges)
+		if fmt.Sprint(merged) != fmt.Sprint(tt.merged) {
+			t.Errorf("%v: got %v, want %v", tt.ranges, merged, tt.merged)
+		}
+	}
+}
+
+func Test_sendRanges(t *testing.T) {
+	setStorage(t, memStorage{"example.com/video.mp4": "0123456789"})
+
+	tests := []struct {
+		rang, ifRange string
+		status        int
+		contentRange  string
+		body          string
+	}{
+		{"", "", http.StatusOK, "", "0123456789"},
+		{"bytes=2-4", "", http.StatusPartialContent, "bytes 2-4/10", "234"},
+		{"bytes=-2", `"10"`, http.StatusPartialContent, "bytes 8-9/10", "89"},
+		{"bytes=-2", `"11"`, http.StatusOK, "", "0123456789"},
+		{"bytes=-2", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusPartialContent, "bytes 8-9/10", "89"},
+		{"bytes=-2", "Mon, 02 Jan 2006 15:04:04 GMT", http.StatusOK, "", "0123456789"},
+		{"bytes=10-", "", http.StatusRequestedRangeNotSatisfiable, "bytes */10", ""},
+		{"bytes=0-9,0-9", "", http.StatusOK, "", "0123456789"},
+		{"bytes=0-1,2-3,3-4", "", http.StatusPartialContent, "bytes 0-4/10", "01234"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/video.mp4", nil)
+		if tt.rang != "" {
+			r.Header.Set("Range", tt.rang)
+		}
+		if tt.ifRange != "" {
+			r.Header.Set("If-Range", tt.ifRange)
+		}
+		StaticWebsiteHandler(w, r)
+
+		if w.Code != tt.status || w.Header().Get("Content-Range") != tt.contentRange || w.Body.String() != tt.body {
+			t.Errorf("%s %s: got %d %q %q", tt.rang, tt.ifRange, w.Code, w.Header().Get("Content-Range"), w.Body.String())
+		}
+	}
+
+	var many, same []string
+	for i := 0; i <= maxRanges; i++ {
+		many = append(many, fmt.Sprintf("%d-%d", 2*i, 2*i))
+		same = append(same, "0-0")
+	}
+	limits := []struct {
+		rang   string
+		status int
+		opens  int
+	}{
+		{"bytes=" + strings.Join(many, ","), http.StatusOK, 1},
+		{"bytes=" + strings.Join(same[1:], ","), http.StatusPartialContent, 1},
+	}
+	for _, tt := range limits {
+		counter := &countingStorage{Storage: memStorage{"example.com/big.bin": strings.Repeat("x", 1000)}}
+		setStorage(t, counter)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/big.bin", nil)
+		r.Header.Set("Range", tt.rang)
+		StaticWebsiteHandler(w, r)
+
+		counter.opens -= 2 // release.json and firebase.json
+		if w.Code != tt.status || counter.opens != tt.opens {
+			t.Errorf("%.20s...: got %d (%d GET), want %d (%d GET)", tt.rang, w.Code, counter.opens, tt.status, tt.opens)
+		}
+	}
+	setStorage(t, memStorage{"example.com/video.mp4": "0123456789"})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/video.mp4", nil)
+	r.Header.Set("Range", "bytes=0-1,-3")
+	StaticWebsiteHandler(w, r)
+
+	mediaType, params, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if w.Code != http.StatusPartialContent || mediaType != "multipart/byteranges" {
+		t.Fatalf("multipart: got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var parts []string
+	mr := multipart.NewReader(strings.NewReader(w.Body.String()), params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(p)
+		parts = append(parts, p.Header.Get("Content-Range")+" "+p.Header.Get("Content-Type")+" "+string(body))
+	}
+	if want := "bytes 0-1/10 text/html 01|bytes 7-9/10 text/html 789"; strings.Join(parts, "|") != want {
+		t.Errorf("multipart: got %q, want %q", strings.Join(parts, "|"), want)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ReleaseConfiguration is the current release of a site, read from its
+// hostingPrefix + "release.json" object.
+//
+// Each release is uploaded, in full, under the site's
+// hostingPrefix + "releases/" + version, and is then published,
+// or rolled back, by updating release.json.
+// Alternatively, a release can be published with a Manifest.
+// Sites without a release.json are served in place.
+type ReleaseConfiguration struct {
+	Version  string `json:"version"`
+	Manifest bool   `json:"manifest,omitempty"`
+}
+
+const versionChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_."
+
+var releases = &Cache[ReleaseConfiguration]{TTL: time.Minute, NegativeTTL: 10 * time.Second}
+
+// initRelease moves the context to the current release of the site, if any.
+func (ctx *HandlerContext) initRelease() error {
+	site := ctx.site()
+	release, err := releases.Get(site, func() (ReleaseConfiguration, error) {
+		release, err := loadRelease(context.WithoutCancel(ctx.r.Context()), ctx.storage, ctx.bucket, ctx.prefix)
+		if err != nil {
+			logErrorf(ctx.r.Context(), "GET %s: %v", site+hostingPrefix+"release.json", err)
+		}
+		return release, err
+	})
+	if err != nil || release.Version == "" {
+		return err
+	}
+
+	if ctx.local == "" {
+		ctx.local = site
+	}
+	if release.Manifest {
+		manifest, err := manifests.Get(site+release.prefix(), func() (*Manifest, error) {
+			manifest, err := loadManifest(context.WithoutCancel(ctx.r.Context()), ctx.storage, ctx.bucket, ctx.prefix, release.Version)
+			if err != nil {
+				logErrorf(ctx.r.Context(), "GET %s: %v", site+release.prefix()+".json", err)
+			}
+			return manifest, err
+		})
+		if err != nil {
+			return err
+		}
+		ctx.storage = manifestStorage{
+			Storage:  ctx.storage,
+			bucket:   ctx.bucket,
+			prefix:   ctx.prefix + release.prefix(),
+			blobs:    ctx.prefix + hostingPrefix + "blobs/",
+			manifest: manifest,
+		}
+	}
+	ctx.release = release.Version
+	ctx.prefix += release.prefix()
+	ctx.tracef("release %s: prefix %q", release.Version, ctx.prefix)
+	ctx.objectBucket, ctx.objectPrefix = ctx.bucket, ctx.prefix
+	return nil
+}
+
+// released reports whether the object is served from the current release.
+// Since rolling back serves older objects, these are validated by an Etag
+// that includes the version, never by date.
+func (ctx *HandlerContext) released() bool {
+	return ctx.release != "" && ctx.objectBucket == ctx.bucket && ctx.objectPrefix == ctx.prefix
+}
+
+// releaseEtag is the Etag of an object of a release, from its stored Etag.
+func releaseEtag(version, etag string) string {
+	return `"` + version + "-" + strings.Trim(strings.TrimPrefix(etag, "W/"), `"`) + `"`
+}
+
+// prefix is where the release is stored, relative to the site.
+func (c ReleaseConfiguration) prefix() string {
+	return hostingPrefix + "releases/" + c.Version
+}
+
+func loadRelease(ctx context.Context, storage Storage, bucket, prefix string) (ReleaseConfiguration, error) {
+	var release ReleaseConfiguration
+
+	res, err := storage.Open(ctx, bucket, prefix+hostingPrefix+"release.json", nil)
+	if err != nil {
+		return release, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return release, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return release, errors.New(http.StatusText(res.StatusCode))
+	}
+
+	err = decodeStrict(res.Body, &release)
+	if err == nil {
+		err = release.validate()
+	}
+	if err != nil {
+		return ReleaseConfiguration{}, &ConfigError{File: hostingPrefix + "release.json", Site: bucket + prefix, Err: err}
+	}
+	return release, nil
+}
+
+func (c ReleaseConfiguration) validate() error {
+	if c.Version == "" || c.Version == "." || c.Version == ".." || strings.Trim(c.Version, versionChars) != "" {
+		return fmt.Errorf("version %q: invalid version", c.Version)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_releases(t *testing.T) {
+	mem := memStorage{
+		"example.com/index.html":                                  "in place",
+		"example.com/.hosting/release.json":                       `{"version": "v1"}`,
+		"example.com/.hosting/releases/v1/index.html":             "v1",
+		"example.com/.hosting/releases/v1/404.html":               "v1 missing",
+		"example.com/.hosting/releases/v1/old.html":               "old",
+		"example.com/.hosting/releases/v2/index.html":             "v2",
+		"example.com/.hosting/releases/v2/404.html":               "v2 missing",
+		"example.com/.hosting/releases/v2/.hosting/firebase.json": `{"cleanUrls": true}`,
+		"example.com/.hosting/releases/v2/new.html":               "new",
+		"example.org/.hosting/release.json":                       `{"version": "../v1"}`,
+		"example.net/index.html":                                  "in place",
+	}
+	setStorage(t, mem)
+
+	tests := []struct {
+		version  string
+		target   string
+		status   int
+		location string
+		body     string
+	}{
+		{"v1", "http://example.com/", http.StatusOK, "", "v1"},
+		{"v1", "http://example.com/old.html", http.StatusOK, "", "old"},
+		{"v1", "http://example.com/new", http.StatusNotFound, "", "v1 missing"},
+		{"v1", "http://example.com/.hosting/releases/v2/new.html", http.StatusNotFound, "", "v1 missing"},
+		{"v2", "http://example.com/", http.StatusOK, "", "v2"},
+		{"v2", "http://example.com/new", http.StatusOK, "", "new"},
+		{"v2", "http://example.com/new.html", http.StatusMovedPermanently, "/new", ""},
+		{"v2", "http://example.com/old", http.StatusNotFound, "", "v2 missing"},
+		{"v2", "http://example.org/", http.StatusInternalServerError, "", ""},
+		{"v2", "http://example.net/", http.StatusOK, "", "in place"},
+	}
+
+	for _, tt := range tests {
+		if mem["example.com/.hosting/release.json"] != `{"version": "`+tt.version+`"}` {
+			mem["example.com/.hosting/release.json"] = `{"version": "` + tt.version + `"}`
+			releases.Delete("example.com")
+		}
+		w, res := serve("GET", tt.target)
+		body := w.Body.String()
+		if res.Status != tt.status || res.Location != tt.location || body != tt.body {
+			t.Errorf("GET %s: got %d %q %q, want %d %q %q", tt.target, res.Status, res.Location, body, tt.status, tt.location, tt.body)
+		}
+	}
+
+	if _, err := loadRelease(context.Background(), mem, "example.org", ""); err == nil {
+		t.Error("want error for invalid version")
+	}
+}
+
+func Test_releaseConditions(t *testing.T) {
+	mem := memStorage{
+		"example.com/.hosting/release.json":           `{"version": "v2"}`,
+		"example.com/.hosting/releases/v1/index.html": "v1",
+		"example.com/.hosting/releases/v2/index.html": "v2",
+	}
+	setStorage(t, mem)
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		Main(w, r)
+		return w
+	}
+
+	w := get("", "")
+	etag := w.Header().Get("Etag")
+	if w.Code != http.StatusOK || etag != `"v2-2"` {
+		t.Fatalf("GET v2: got %d %q", w.Code, etag)
+	}
+	if w := get("If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("GET v2 If-None-Match: got %d", w.Code)
+	}
+
+	// Roll back, to objects modified before the client last got v2.
+	mem["example.com/.hosting/release.json"] = `{"version": "v1"}`
+	if w := get("If-None-Match", etag); w.Code != http.StatusOK || w.Body.String() != "v1" {
+		t.Errorf("GET v1 If-None-Match: got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("If-Modified-Since", w.Header().Get("Last-Modified")); w.Code != http.StatusOK || w.Body.String() != "v1" {
+		t.Errorf("GET v1 If-Modified-Since: got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Storage serves objects from an S3 compatible object store,
+// signing requests with AWS Signature Version 4.
+//
+// Requests are anonymous if AccessKey is empty.
+// PathStyle addresses buckets as Endpoint/bucket, instead of bucket.Endpoint.
+type S3Storage struct {
+	Endpoint     string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	PathStyle    bool
+	Client       *http.Client
+}
+
+func (s S3Storage) Stat(ctx context.Context, bucket, object string) (*http.Response, error) {
+	return s.do(ctx, "HEAD", bucket, object, "", nil)
+}
+
+func (s S3Storage) Open(ctx context.Context, bucket, object string, header http.Header) (*http.Response, error) {
+	return s.do(ctx, "GET", bucket, object, "", header)
+}
+
+func (s S3Storage) Website(ctx context.Context, bucket string) (WebsiteConfiguration, error) {
+	var config struct {
+		IndexDocument struct{ Suffix string }
+		ErrorDocument struct{ Key string }
+		Code          string
+	}
+
+	res, err := s.do(ctx, "GET", bucket, "/", "website", nil)
+	if err != nil {
+		return WebsiteConfiguration{}, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return WebsiteConfiguration{}, errors.New(http.StatusText(res.StatusCode))
+	}
+
+	if err = xml.NewDecoder(res.Body).Decode(&config); err != nil {
+		return WebsiteConfiguration{}, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		if config.Code == "NoSuchWebsiteConfiguration" {
+			return WebsiteConfiguration{}, nil
+		}
+		return WebsiteConfiguration{}, errors.New(config.Code)
+	}
+
+	return WebsiteConfiguration{
+		MainPageSuffix: config.IndexDocument.Suffix,
+		NotFoundPage:   config.ErrorDocument.Key,
+	}, nil
+}
+
+func (s S3Storage) do(ctx context.Context, method, bucket, object, query string, header http.Header) (*http.Response, error) {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := url.PathUnescape(object)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host, RawQuery: query}
+	if s.PathStyle {
+		u.Path = "/" + bucket + name
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = name
+	}
+	u.RawPath = awsEscape(u.Path, false)
+	if query != "" && !strings.Contains(query, "=") {
+		u.RawQuery = query + "="
+	}
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range openHeaders {
+		if v := header.Get(k); v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+	if s.AccessKey != "" {
+		signV4(req, s.Region, s.AccessKey, s.SecretKey, s.SessionToken, time.Now())
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK || res.StatusCode == http.StatusPartialContent {
+		size := ""
+		if res.ContentLength >= 0 {
+			size = strconv.FormatInt(res.ContentLength, 10)
+		}
+		if i := strings.LastIndexByte(res.Header.Get("Content-Range"), '/'); i >= 0 {
+			size = res.Header.Get("Content-Range")[i+1:]
+		}
+		// Leave the size unset if unknown.
+		if size != "" && size != "*" {
+			res.Header.Set("x-goog-stored-content-length", size)
+		}
+		if enc := res.Header.Get("Content-Encoding"); enc != "" {
+			res.Header.Set("x-goog-stored-content-encoding", enc)
+		}
+	}
+	return res, nil
+}
+
+func signV4(req *http.Request, region, accessKey, secretKey, sessionToken string, t time.Time) {
+	const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	amzdate := t.UTC().Format("20060102T150405Z")
+	scope := amzdate[:8] + "/" + region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzdate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyHash)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	names := []string{"host"}
+	for k := range req.Header {
+		if k := strings.ToLower(k); k == "range" || strings.HasPrefix(k, "x-amz-") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var headers strings.Builder
+	for _, k := range names {
+		v := req.Host
+		if v == "" {
+			v = req.URL.Host
+		}
+		if k != "host" {
+			v = req.Header.Get(k)
+		}
+		headers.WriteString(k + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, false),
+		awsQuery(req.URL.Query()),
+		headers.String(),
+		signed,
+		emptyHash,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonical))
+	sts := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), amzdate[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, sts))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func awsQuery(query url.Values) string {
+	var params []string
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func awsEscape(s string, slash bool) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,198 @@
+//go:build !gae
+// +build !gae
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+func main() {
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		serveMain(args)
+	case "deploy":
+		deployMain(args)
+	case "explain":
+		explainMain(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", cmd)
+		os.Exit(2)
+	}
+}
+
+func serveMain(args []string) {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":"+port, "listen `address`")
+	cert := flags.String("cert", "", "TLS certificate `file`")
+	key := flags.String("key", "", "TLS key `file`")
+	root := flags.String("root", "", "serve from a local `directory`, instead of Cloud Storage")
+	flags.Parse(args)
+
+	configure()
+	if *root != "" {
+		storage = newLocalStorage(*root)
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           http.HandlerFunc(Main),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	var err error
+	log.Printf("Listening on %s", *addr)
+	if *cert != "" || *key != "" {
+		err = server.ListenAndServeTLS(*cert, *key)
+	} else {
+		err = server.ListenAndServe()
+	}
+	log.Fatal(err)
+}
+
+func deployMain(args []string) {
+	flags := flag.NewFlagSet("deploy", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s deploy -bucket bucket [flags] [public]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	deployer := Deployer{}
+	flags.StringVar(&deployer.Bucket, "bucket", "", "deploy to `bucket`")
+	flags.StringVar(&deployer.Prefix, "prefix", "", "deploy to a `prefix` within bucket")
+	flags.StringVar(&deployer.Version, "version", "", "release `version` (default: the current UTC time)")
+	flags.IntVar(&deployer.Keep, "keep", 0, "keep the `n` most recent releases, deleting older ones and unreferenced blobs (default: keep all)")
+	flags.StringVar(&deployer.Endpoint, "endpoint", "", "Cloud Storage `URL`, for an emulator (unauthenticated)")
+	flags.BoolVar(&deployer.DryRun, "n", false, "show what would change, without changing it")
+	config := flags.String("config", "firebase.json", "hosting configuration `file`")
+	flags.Parse(args)
+
+	if deployer.Bucket == "" || flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(*config)
+	if os.IsNotExist(err) {
+		data, err = nil, nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if deployer.Endpoint != "" {
+		deployer.Client = http.DefaultClient
+	} else if deployer.Client, err = google.DefaultClient(ctx, gcsWriteScope); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := deployer.Deploy(ctx, flags.Arg(0), data); err != nil {
+		log.Fatalf("Deploy failed:\n%v", err)
+	}
+}
+
+func explainMain(args []string) {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s explain [flags] URL\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	root := flags.String("root", os.Getenv("LOCAL_STORAGE_ROOT"), "serve from a local `directory`, instead of Cloud Storage")
+	config := flags.String("config", "", "hosting configuration `file` for the site")
+	method := flags.String("X", "GET", "request `method`")
+	header := headerFlag{}
+	flags.Var(header, "H", "request `header`, as in \"Name: value\" (repeatable)")
+	flags.Parse(args)
+
+	u, err := url.Parse(flags.Arg(0))
+	if flags.NArg() != 1 || err != nil || u.Host == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	configure()
+	if *root != "" {
+		storage = newLocalStorage(*root)
+	}
+	if *config != "" {
+		f, err := os.Open(*config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bucket, prefix, _ := resolveHost(u.Host)
+		firebase[bucket+prefix], err = parseFirebase(context.Background(), f, *config, bucket+prefix)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Invalid hosting configuration:\n%v", err)
+		}
+	}
+
+	r := httptest.NewRequest(*method, u.String(), nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	explain(os.Stdout, r)
+}
+
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	return ""
+}
+
+func (h headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	if !ok {
+		return errors.New("missing colon")
+	}
+	http.Header(h).Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	return nil
+}
+
+func newContext(r *http.Request) context.Context {
+	return r.Context()
+}
+
+func logErrorf(ctx context.Context, format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+func logWarningf(ctx context.Context, format string, args ...interface{}) {
+	log.Printf("WARNING: "+format, args...)
+}
+
+// gcsTransport pools connections to Cloud Storage with storageTransport.
+func gcsTransport() http.RoundTripper {
+	return storageTransport
+}
+
+// streamsBody reports whether object bodies are streamed by sendBlobBody.
+func (ctx *HandlerContext) streamsBody() bool {
+	return true
+}
+
+func (ctx *HandlerContext) sendBlob(metadata http.Header) HttpResult {
+	return ctx.sendBlobBody(metadata)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Storage is an object store that can back a static website.
+//
+// Objects are named by their URL escaped path, starting with a slash.
+// Responses follow the conventions of the Cloud Storage XML API:
+// metadata is returned in the Etag, Last-Modified, Content-Type, Cache-Control,
+// x-goog-stored-content-length and x-goog-stored-content-encoding headers,
+// and a missing object is reported with a 404 status code.
+type Storage interface {
+	// Stat returns the metadata of an object, with an empty body.
+	Stat(ctx context.Context, bucket, object string) (*http.Response, error)
+	// Open returns the metadata and content of an object.
+	// Header may carry any of the openHeaders request headers, which it may ignore.
+	Open(ctx context.Context, bucket, object string, header http.Header) (*http.Response, error)
+	// Website returns the website configuration of a bucket.
+	Website(ctx context.Context, bucket string) (WebsiteConfiguration, error)
+}
+
+var storage Storage = GCSStorage{}
+
+// storageTransport pools connections to storage across requests.
+// Unlike http.DefaultTransport, it keeps as many idle connections per host as overall,
+// as most requests go to a single host.
+var storageTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = t.MaxIdleConns
+	return t
+}()
+
+// openHeaders are the request headers Open can pass on to the store.
+var openHeaders = []string{"Range", "Accept-Encoding", "If-None-Match", "If-Modified-Since"}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigError is a problem with a hosting configuration,
+// located by file, site and rule.
+type ConfigError struct {
+	File string
+	Site string
+	Rule string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	for _, s := range []string{e.File, e.Site, e.Rule} {
+		if s != "" {
+			b.WriteString(s)
+			b.WriteString(": ")
+		}
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// loadFirebaseFile loads and validates the local firebase.json,
+// which maps sites to their hosting configuration.
+// A missing file is not an error.
+func loadFirebaseFile(name string) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var raw map[string]json.RawMessage
+	if err := decodeStrict(f, &raw); err != nil {
+		return &ConfigError{File: name, Err: err}
+	}
+
+	var errs []error
+	sites := map[string]FirebaseConfiguration{}
+	for _, site := range sortedKeys(raw) {
+		var config FirebaseConfiguration
+		unknown, err := decodeConfig(bytes.NewReader(raw[site]), &config)
+		if err != nil {
+			errs = append(errs, &ConfigError{File: name, Site: site, Err: err})
+			continue
+		}
+		for _, key := range unknown {
+			log.Printf("WARNING: %v", unknownKeyError(name, site, key))
+		}
+		invalid := config.validate(name, site)
+		if err := config.compile(); err != nil && len(invalid) == 0 {
+			invalid = append(invalid, &ConfigError{File: name, Site: site, Err: err})
+		}
+		errs = append(errs, invalid...)
+		sites[site] = config
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	firebase = sites
+	return nil
+}
+
+func decodeStrict(r io.Reader, v interface{}) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return decode(data, v, true)
+}
+
+// decodeConfig decodes a Firebase configuration, which may carry keys
+// for other Firebase products, or hosting features that don't apply here.
+// It returns the keys it doesn't know, except ignoredKeys.
+func decodeConfig(r io.Reader, v interface{}) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := decode(data, v, false); err != nil {
+		return nil, err
+	}
+	return unknownKeys(data, reflect.TypeOf(v), ""), nil
+}
+
+// ignoredKeys are the Firebase configuration keys known not to apply here.
+var ignoredKeys = map[string]bool{
+	// Other products.
+	"database": true, "emulators": true, "extensions": true, "firestore": true,
+	"functions": true, "remoteconfig": true, "storage": true,
+	// Hosting features handled by the Firebase CLI, or unsupported.
+	"appAssociation": true, "frameworksBackend": true, "i18n": true,
+	"postdeploy": true, "predeploy": true, "site": true, "source": true, "target": true,
+}
+
+func unknownKeyError(file, site, key string) error {
+	return &ConfigError{File: file, Site: site, Err: fmt.Errorf("unknown key %q, ignored", key)}
+}
+
+// unknownKeys lists the keys of JSON data that don't map to fields of type t.
+func unknownKeys(data []byte, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(data, &object) != nil {
+			return nil
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(object) {
+			name := key
+			if path != "" {
+				name = path + "." + key
+			}
+			if f, ok := fields[strings.ToLower(key)]; ok {
+				unknown = append(unknown, unknownKeys(object[key], f, name)...)
+			} else if !ignoredKeys[key] {
+				unknown = append(unknown, name)
+			}
+		}
+
+	case reflect.Slice:
+		var array []json.RawMessage
+		if json.Unmarshal(data, &array) != nil {
+			return nil
+		}
+		for i, elem := range array {
+			unknown = append(unknown, unknownKeys(elem, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return unknown
+}
+
+// jsonFields maps the (lower case) JSON names of the fields of a struct type to their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case name == "-":
+		case f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct:
+			for k, v := range jsonFields(f.Type) {
+				if _, ok := fields[k]; !ok {
+					fields[k] = v
+				}
+			}
+		case f.IsExported():
+			if name == "" {
+				name = f.Name
+			}
+			fields[strings.ToLower(name)] = f.Type
+		}
+	}
+	return fields
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func decode(data []byte, v interface{}, strict bool) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(v)
+
+	var syntax *json.SyntaxError
+	switch {
+	case errors.As(err, &syntax):
+		return fmt.Errorf("line %d: %w", lineOf(data, syntax.Offset), err)
+	case err == nil && dec.More():
+		return errors.New("unexpected data after top-level value")
+	}
+	return err
+}
+
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return 1 + bytes.Count(data[:offset], []byte("\n"))
+}
+
+// validate checks that every rule of the configuration can be applied.
+func (c FirebaseConfiguration) validate(file, site string) []error {
+	var errs []error
+	fail := func(rule string, format string, args ...interface{}) {
+		errs = append(errs, &ConfigError{File: file, Site: site, Rule: rule, Err: fmt.Errorf(format, args...)})
+	}
+
+	for i, redirect := range c.Redirects {
+		rule := fmt.Sprintf("redirects[%d]", i)
+		pattern, err := compileSource(redirect.Source, redirect.Regex)
+		if err != nil {
+			fail(rule, "%s: %v", describeSource(redirect.Source, redirect.Regex), err)
+		}
+		switch redirect.Type {
+		case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			fail(rule, "type %d: not one of 301, 302, 303, 307, 308", redirect.Type)
+		}
+		if redirect.Destination == "" {
+			fail(rule, "missing destination")
+		}
+		if pattern != nil {
+			captures := map[string]bool{}
+			for i, name := range pattern.SubexpNames() {
+				captures[name] = name != ""
+				captures[strconv.Itoa(i)] = i > 0
+			}
+			for _, name := range templateNames(redirect.Destination) {
+				if !captures[name] {
+					fail(rule, "destination %q: no capture %q in %s", redirect.Destination, name, describeSource(redirect.Source, redirect.Regex))
+				}
+			}
+		}
+	}
+
+	for i, rewrite := range c.Rewrites {
+		rule := fmt.Sprintf("rewrites[%d]", i)
+		if _, err := compileSource(rewrite.Source, rewrite.Regex); err != nil {
+			fail(rule, "%s: %v", describeSource(rewrite.Source, rewrite.Regex), err)
+		}
+		switch {
+		case rewrite.Destination != "" && rewrite.Proxy != "":
+			fail(rule, "both destination and proxy")
+		case rewrite.Proxy != "":
+			if u, err := url.Parse(rewrite.Proxy); err != nil {
+				fail(rule, "proxy %q: %v", rewrite.Proxy, err)
+			} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+				fail(rule, "proxy %q: not an absolute http(s) URL", rewrite.Proxy)
+			}
+		case rewrite.Destination == "":
+			fail(rule, "missing destination or proxy")
+		}
+		if bucket, ok := rewriteBucket(rewrite.Destination); ok && !validBucket(bucket) {
+			fail(rule, "destination %q: invalid bucket", rewrite.Destination)
+		}
+	}
+
+	for i, headers := range c.Headers {
+		rule := fmt.Sprintf("headers[%d]", i)
+		if _, err := compileSource(headers.Source, headers.Regex); err != nil {
+			fail(rule, "%s: %v", describeSource(headers.Source, headers.Regex), err)
+		}
+		for j, h := range headers.Headers {
+			if h.Key == "" || strings.ContainsAny(h.Key, " \t\r\n:") {
+				fail(fmt.Sprintf("%s.headers[%d]", rule, j), "invalid key %q", h.Key)
+			}
+		}
+	}
+
+	return errs
+}
+
+func describeSource(source, regex string) string {
+	switch {
+	case regex == "":
+		return fmt.Sprintf("source %q", source)
+	case source == "":
+		return fmt.Sprintf("regex %q", regex)
+	default:
+		return fmt.Sprintf("source %q, regex %q", source, regex)
+	}
+}
+
+// rewriteBucket returns the bucket named by a gs:// rewrite destination.
+func rewriteBucket(destination string) (string, bool) {
+	if !strings.HasPrefix(destination, "gs://") {
+		return "", false
+	}
+	bucket := destination[len("gs://"):]
+	if i := strings.IndexByte(bucket, '/'); i >= 0 {
+		bucket = bucket[:i]
+	}
+	return bucket, true
+}